@@ -0,0 +1,102 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// AuditRecord stores a change made in aws on behalf of an event
+type AuditRecord struct {
+	Timestamp    time.Time   `json:"timestamp"`
+	UUID         string      `json:"_uuid"`
+	BatchID      string      `json:"_batch_id"`
+	Action       string      `json:"action"`
+	Operation    string      `json:"operation"`
+	HostedZoneID string      `json:"hosted_zone_id,omitempty"`
+	Change       interface{} `json:"change"`
+	ErrorMessage string      `json:"error_message,omitempty"`
+}
+
+// audit receives a record of every change made in aws, it does nothing
+// unless a sink is set
+var audit = func(ev *Event, record AuditRecord) {}
+
+// publishAudit sends audit records to the route53.audit subject
+func publishAudit(ev *Event, record AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		ev.logf(levelError, "Error: could not encode audit record of %s: %s", record.Operation, err.Error())
+		return
+	}
+
+	if err := ev.getPublisher().Publish("route53.audit", data); err != nil {
+		ev.logf(levelError, "Error: could not publish audit record of %s: %s", record.Operation, err.Error())
+	}
+}
+
+// isMutation returns true for aws operations that change something
+func isMutation(operation string) bool {
+	for _, prefix := range []string{"Get", "List", "Test"} {
+		if strings.HasPrefix(operation, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// auditHandler audits the aws calls of an event that change something, once
+// they are complete
+func auditHandler(ev *Event) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "route53.AuditHandler",
+		Fn: func(r *request.Request) {
+			if !isMutation(r.Operation.Name) {
+				return
+			}
+
+			record := AuditRecord{
+				Timestamp:    now().UTC(),
+				UUID:         ev.UUID,
+				BatchID:      ev.BatchID,
+				Action:       ev.resource + "." + ev.action,
+				Operation:    r.Operation.Name,
+				HostedZoneID: auditZoneID(r),
+				Change:       r.Params,
+			}
+
+			if r.Error != nil {
+				record.ErrorMessage = errorMessage(r.Error)
+			}
+
+			audit(ev, record)
+		},
+	}
+}
+
+// auditZoneID returns the hosted zone a call changed, from its input or, for
+// a new zone, its output
+func auditZoneID(r *request.Request) string {
+	for _, path := range []string{"HostedZoneId", "Id"} {
+		if values, _ := awsutil.ValuesAtPath(r.Params, path); len(values) > 0 {
+			if id, ok := values[0].(*string); ok && id != nil {
+				return *id
+			}
+		}
+	}
+
+	if values, _ := awsutil.ValuesAtPath(r.Data, "HostedZone.Id"); len(values) > 0 {
+		if id, ok := values[0].(*string); ok && id != nil {
+			return *id
+		}
+	}
+
+	return ""
+}
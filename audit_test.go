@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testCreateHostedZoneResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<CreateHostedZoneResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">
+  <HostedZone><Id>/hostedzone/AUDIT</Id><Name>test.</Name><CallerReference>ref</CallerReference></HostedZone>
+  <ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status><SubmittedAt>2017-01-01T00:00:00Z</SubmittedAt></ChangeInfo>
+  <DelegationSet><NameServers><NameServer>ns-1.test.</NameServer></NameServers></DelegationSet>
+</CreateHostedZoneResponse>`
+
+func TestAudit(t *testing.T) {
+	Convey("Given an aws endpoint and an audit sink", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(testCreateHostedZoneResponse))
+		}))
+
+		var records []AuditRecord
+		audit = func(ev *Event, record AuditRecord) {
+			records = append(records, record)
+		}
+		Reset(func() {
+			server.Close()
+			audit = func(ev *Event, record AuditRecord) {}
+		})
+
+		e := testEvent
+		e.resource = "route53"
+		e.action = "create"
+		svc := route53.New(newSession(&e), clientConfig(&e, "us-east-1").WithEndpoint(server.URL))
+
+		Convey("When creating a zone", func() {
+			_, err := svc.CreateHostedZone(&route53.CreateHostedZoneInput{
+				CallerReference: aws.String("ref"),
+				Name:            aws.String("test"),
+			})
+
+			Convey("It should audit the change", func() {
+				So(err, ShouldBeNil)
+				So(len(records), ShouldEqual, 1)
+				So(records[0].UUID, ShouldEqual, "test")
+				So(records[0].Action, ShouldEqual, "route53.create")
+				So(records[0].Operation, ShouldEqual, "CreateHostedZone")
+				So(records[0].HostedZoneID, ShouldEqual, "/hostedzone/AUDIT")
+				So(records[0].Timestamp.IsZero(), ShouldBeFalse)
+
+				data, _ := json.Marshal(records[0])
+				So(string(data), ShouldContainSubstring, `"change":{"CallerReference":"ref"`)
+			})
+		})
+
+		Convey("When reading a zone", func() {
+			svc.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String("/hostedzone/AUDIT")})
+
+			Convey("It should not audit the call", func() {
+				So(len(records), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given an audit record", t, func() {
+		pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
+		audited := make(chan *nats.Msg, 1)
+		pub.ChanSubscribe("route53.audit", audited)
+
+		e := Event{publisher: pub}
+
+		Convey("When publishing it", func() {
+			publishAudit(&e, AuditRecord{UUID: "test", Operation: "DeleteHostedZone", HostedZoneID: "/hostedzone/TEST"})
+
+			Convey("It should be sent to the audit subject", func() {
+				msg, timeout := waitMsg(audited)
+				So(timeout, ShouldBeNil)
+
+				var record AuditRecord
+				json.Unmarshal(msg.Data, &record)
+				So(record.Operation, ShouldEqual, "DeleteHostedZone")
+				So(record.HostedZoneID, ShouldEqual, "/hostedzone/TEST")
+			})
+		})
+	})
+}
@@ -0,0 +1,208 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// coalesceWindow is how long updates of a zone are held to be applied
+// together, disabled when zero
+var coalesceWindow time.Duration
+
+var afterFunc = time.AfterFunc
+
+// updateCoalescer holds the updates of each hosted zone until its window
+// closes, so a burst of updates makes a single set of changes
+type updateCoalescer struct {
+	mu      sync.Mutex
+	pending map[string][]*Event
+	// applying is held while the updates of a zone are applied
+	applying map[string]*sync.Mutex
+}
+
+var coalescer = newUpdateCoalescer()
+
+func newUpdateCoalescer() *updateCoalescer {
+	return &updateCoalescer{
+		pending:  make(map[string][]*Event),
+		applying: make(map[string]*sync.Mutex),
+	}
+}
+
+// coalesces returns true for updates that describe the whole zone, which
+// a later update of the same zone supersedes
+func coalesces(ev *Event) bool {
+	return coalesceWindow > 0 &&
+		ev.resource == "route53" && ev.action == "update" &&
+		ev.HostedZoneID != "" && len(ev.Zones) == 0 &&
+		!ev.AppendOnly && !ev.Targeted && len(ev.RecordsToDelete) == 0
+}
+
+// mutates returns true for events that change a zone
+func mutates(ev *Event) bool {
+	if ev.resource != "route53" {
+		return false
+	}
+
+	switch ev.action {
+	case "create", "update", "upsert", "delete", "import":
+		return true
+	}
+
+	return false
+}
+
+// add holds the update, opening a window for its zone if there is none
+func (c *updateCoalescer) add(ev *Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := heldID(ev.HostedZoneID)
+
+	// registers the zone, so events that are not held wait for it
+	c.lockOf(id)
+
+	if _, ok := c.pending[id]; !ok {
+		afterFunc(coalesceWindow, func() {
+			c.flush(id)
+		})
+	}
+
+	c.pending[id] = append(c.pending[id], ev)
+}
+
+// flush applies the latest update held for the zone, then completes or
+// errors every update it stood in for
+func (c *updateCoalescer) flush(id string) {
+	applying := c.zoneLock(id)
+	applying.Lock()
+	defer applying.Unlock()
+	defer c.release(id, applying)
+
+	c.mu.Lock()
+	events := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	applied := events[len(events)-1]
+	applied.budget = newRetryBudget()
+
+	if len(events) > 1 {
+		applied.logf(levelInfo, "Info: applying %d updates of zone %s together", len(events), id)
+	}
+
+	err := budgetError(applied, runEvent(applied, updateRoute53))
+
+	for _, ev := range events {
+		ev.Result = applied.Result
+		ev.Coalesced = len(events)
+
+		if err != nil {
+			ev.Error(err)
+			continue
+		}
+
+		ev.Complete()
+	}
+}
+
+// release forgets the lock of a zone once its updates are applied and no
+// more are held
+func (c *updateCoalescer) release(id string, applying *sync.Mutex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pending[id]; !ok && c.applying[id] == applying {
+		delete(c.applying, id)
+	}
+}
+
+// flushBefore applies the updates held for the zones of an event that is not
+// held itself, so it never overtakes them. Zones without a hosted zone id are
+// looked up by name
+func (c *updateCoalescer) flushBefore(ev *Event) {
+	for _, id := range c.heldZones(ev) {
+		c.flush(id)
+	}
+}
+
+// heldZones returns the ids of the zones of the event that may have updates
+// held or being applied
+func (c *updateCoalescer) heldZones(ev *Event) []string {
+	if len(c.zones()) == 0 {
+		return nil
+	}
+
+	events := []*Event{ev}
+	if len(ev.Zones) > 0 {
+		events = nil
+		for i := range ev.Zones {
+			zev := ev.forZone(&ev.Zones[i])
+			events = append(events, &zev)
+		}
+	}
+
+	var ids []string
+	for _, zev := range events {
+		id := zev.HostedZoneID
+
+		// a zone that can not be found has no updates held
+		if id == "" {
+			var err error
+			if id, err = getZoneID(zev); err != nil {
+				continue
+			}
+		}
+
+		ids = append(ids, heldID(id))
+	}
+
+	return ids
+}
+
+// zones returns the ids of every zone with updates held or being applied
+func (c *updateCoalescer) zones() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ids []string
+	for id := range c.applying {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// heldID returns the hosted zone id without its prefix, as events may give
+// it either way
+func heldID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+func (c *updateCoalescer) zoneLock(id string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lockOf(id)
+}
+
+// lockOf returns the lock held while the updates of a zone are applied,
+// callers hold mu
+func (c *updateCoalescer) lockOf(id string) *sync.Mutex {
+	l, ok := c.applying[id]
+	if !ok {
+		l = &sync.Mutex{}
+		c.applying[id] = l
+	}
+
+	return l
+}
@@ -0,0 +1,189 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCoalesceUpdates(t *testing.T) {
+	Convey("Given a coalescing window", t, func() {
+		pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
+		done := make(chan *nats.Msg, 10)
+		pub.ChanSubscribe("route53.update.aws.done", done)
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+		}
+		testClient(c)
+
+		var timers []func()
+		coalesceWindow = time.Second
+		afterFunc = func(d time.Duration, f func()) *time.Timer {
+			timers = append(timers, f)
+			return nil
+		}
+		coalescer = newUpdateCoalescer()
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+			coalesceWindow = 0
+			afterFunc = time.AfterFunc
+		})
+
+		Convey("When three updates of a zone arrive inside the window", func() {
+			for i, value := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+				e := testEvent
+				e.UUID = []string{"first", "second", "third"}[i]
+				e.HostedZoneID = "/hostedzone/TEST"
+				e.Records = Records{{Entry: "www.test", Type: "A", Values: []string{value}, TTL: 300}}
+				data, _ := json.Marshal(e)
+
+				ev := &Event{publisher: pub}
+				ev.Process("route53.update.aws", data)
+				So(coalesces(ev), ShouldBeTrue)
+				coalescer.add(ev)
+			}
+
+			Convey("It should open a single window for the zone", func() {
+				So(len(timers), ShouldEqual, 1)
+				So(len(c.changes), ShouldEqual, 0)
+			})
+
+			Convey("And the window closes", func() {
+				timers[0]()
+
+				Convey("It should apply the latest update once", func() {
+					So(len(c.changes), ShouldEqual, 1)
+					changes := c.changes[0].ChangeBatch.Changes
+					So(len(changes), ShouldEqual, 1)
+					So(*changes[0].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.3")
+				})
+
+				Convey("It should complete each update", func() {
+					var uuids []string
+					for range []int{1, 2, 3} {
+						msg, timeout := waitMsg(done)
+						So(timeout, ShouldBeNil)
+
+						var completed Event
+						json.Unmarshal(msg.Data, &completed)
+						So(completed.Coalesced, ShouldEqual, 3)
+						So(completed.ChangeCount, ShouldEqual, 1)
+						uuids = append(uuids, completed.UUID)
+					}
+					So(uuids, ShouldResemble, []string{"first", "second", "third"})
+				})
+			})
+		})
+
+		Convey("When another event of the zone arrives while an update is held", func() {
+			for i, subject := range []string{"route53.update.aws", "route53.delete.aws"} {
+				e := testEvent
+				e.UUID = []string{"held", "delete"}[i]
+				e.HostedZoneID = "/hostedzone/TEST"
+				data, _ := json.Marshal(e)
+
+				ev := &Event{publisher: pub}
+				ev.Process(subject, data)
+
+				if coalesces(ev) {
+					coalescer.add(ev)
+					continue
+				}
+
+				So(mutates(ev), ShouldBeTrue)
+				coalescer.flushBefore(ev)
+			}
+
+			Convey("It should apply the held update before it", func() {
+				So(len(c.changes), ShouldEqual, 1)
+				So(*c.changes[0].ChangeBatch.Changes[0].Action, ShouldEqual, "CREATE")
+
+				msg, timeout := waitMsg(done)
+				So(timeout, ShouldBeNil)
+				So(string(msg.Data), ShouldContainSubstring, `"held"`)
+			})
+
+			Convey("It should have nothing left to apply when the window closes", func() {
+				timers[0]()
+				So(len(c.changes), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When an event that only names its zone arrives while an update is held", func() {
+			e := testEvent
+			e.HostedZoneID = "/hostedzone/TEST"
+			data, _ := json.Marshal(e)
+
+			held := &Event{publisher: pub}
+			held.Process("route53.update.aws", data)
+			coalescer.add(held)
+
+			named := testEvent
+			named.AppendOnly = true
+			data, _ = json.Marshal(named)
+
+			ev := &Event{publisher: pub}
+			ev.Process("route53.update.aws", data)
+			coalescer.flushBefore(ev)
+
+			Convey("It should apply the held update of its zone first", func() {
+				So(len(c.changes), ShouldEqual, 1)
+				So(len(coalescer.pending), ShouldEqual, 0)
+			})
+
+			Convey("It should forget the zone once its updates are applied", func() {
+				So(len(coalescer.applying), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an event naming another zone arrives while an update is held", func() {
+			e := testEvent
+			e.HostedZoneID = "/hostedzone/TEST"
+			data, _ := json.Marshal(e)
+
+			held := &Event{publisher: pub}
+			held.Process("route53.update.aws", data)
+			coalescer.add(held)
+
+			named := testEvent
+			named.Name = "other"
+			named.AppendOnly = true
+			data, _ = json.Marshal(named)
+
+			ev := &Event{publisher: pub}
+			ev.Process("route53.update.aws", data)
+			coalescer.flushBefore(ev)
+
+			Convey("It should keep the update held", func() {
+				So(len(c.changes), ShouldEqual, 0)
+				So(len(coalescer.pending), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When an append only update arrives", func() {
+			e := testEvent
+			e.HostedZoneID = "/hostedzone/TEST"
+			e.AppendOnly = true
+			data, _ := json.Marshal(e)
+
+			ev := &Event{publisher: pub}
+			ev.Process("route53.update.aws", data)
+
+			Convey("It should not be held", func() {
+				So(coalesces(ev), ShouldBeFalse)
+			})
+		})
+	})
+}
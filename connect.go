@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// natsMaxReconnects is the number of reconnects tried after losing nats
+var natsMaxReconnects = 60
+
+// natsReconnectWait is the base wait between attempts to reach nats
+var natsReconnectWait = 2 * time.Second
+
+// natsConnectAttempts is the number of attempts to reach nats on startup
+var natsConnectAttempts = 10
+
+var natsConnect = nats.Connect
+
+// jitter returns the wait plus a random amount of up to the wait again, so
+// several connectors restarted together don't reconnect in step
+func jitter(wait time.Duration) time.Duration {
+	return wait + time.Duration(rand.Int63n(int64(wait)))
+}
+
+// natsOptions returns the options to connect to nats with, so the connector
+// survives broker restarts. Subscriptions are sent again on reconnect.
+func natsOptions() []nats.Option {
+	return []nats.Option{
+		nats.MaxReconnects(natsMaxReconnects),
+		// each attempt waits for its own jittered delay
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return jitter(natsReconnectWait)
+		}),
+		nats.DisconnectHandler(func(c *nats.Conn) {
+			logf(levelWarn, "Warning: disconnected from nats")
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			logf(levelInfo, "Info: reconnected to nats at %s", c.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(c *nats.Conn) {
+			logf(levelError, "Error: connection to nats closed")
+		}),
+	}
+}
+
+// connectNats connects to nats, retrying with a jittered wait while it is
+// unavailable on startup
+func connectNats(uri string) (*nats.Conn, error) {
+	for attempt := 1; ; attempt++ {
+		conn, err := natsConnect(uri, natsOptions()...)
+		if err == nil {
+			return conn, nil
+		}
+
+		if attempt >= natsConnectAttempts {
+			return nil, err
+		}
+
+		logf(levelWarn, "Warning: could not connect to nats, attempt %d of %d: %s", attempt, natsConnectAttempts, err.Error())
+		sleep(jitter(natsReconnectWait))
+	}
+}
@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNatsOptions(t *testing.T) {
+	Convey("Given the nats connection options", t, func() {
+		opts := nats.GetDefaultOptions()
+		for _, opt := range natsOptions() {
+			So(opt(&opts), ShouldBeNil)
+		}
+
+		Convey("It should reconnect with a jittered wait", func() {
+			So(opts.AllowReconnect, ShouldBeTrue)
+			So(opts.MaxReconnect, ShouldEqual, natsMaxReconnects)
+
+			for attempt := 1; attempt <= 3; attempt++ {
+				wait := opts.CustomReconnectDelayCB(attempt)
+				So(wait, ShouldBeGreaterThanOrEqualTo, natsReconnectWait)
+				So(wait, ShouldBeLessThan, 2*natsReconnectWait)
+			}
+		})
+
+		Convey("It should register the connection handlers", func() {
+			So(opts.DisconnectedCB, ShouldNotBeNil)
+			So(opts.ReconnectedCB, ShouldNotBeNil)
+			So(opts.ClosedCB, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConnectNats(t *testing.T) {
+	Convey("Given nats is unavailable on startup", t, func() {
+		var intervals []time.Duration
+		sleep = func(d time.Duration) {
+			intervals = append(intervals, d)
+		}
+
+		var attempts int
+		natsConnect = func(uri string, options ...nats.Option) (*nats.Conn, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, nats.ErrNoServers
+			}
+			return &nats.Conn{}, nil
+		}
+		Reset(func() {
+			sleep = time.Sleep
+			natsConnect = nats.Connect
+		})
+
+		Convey("When it becomes available", func() {
+			conn, err := connectNats("nats://127.0.0.1:4222")
+
+			Convey("It should retry until connected", func() {
+				So(err, ShouldBeNil)
+				So(conn, ShouldNotBeNil)
+				So(attempts, ShouldEqual, 3)
+				So(len(intervals), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When it stays unavailable", func() {
+			natsConnect = func(uri string, options ...nats.Option) (*nats.Conn, error) {
+				attempts++
+				return nil, errors.New("connection refused")
+			}
+			_, err := connectNats("nats://127.0.0.1:4222")
+
+			Convey("It should give up after the attempts", func() {
+				So(err.Error(), ShouldEqual, "connection refused")
+				So(attempts, ShouldEqual, natsConnectAttempts)
+			})
+		})
+	})
+}
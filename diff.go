@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func recordSetKey(name, recordType, setIdentifier string) string {
+	return strings.ToLower(entryName(name)) + " " + recordType + " " + setIdentifier
+}
+
+// findRecordSet returns the existing record set a record would replace
+func findRecordSet(existing []*route53.ResourceRecordSet, record Record) *route53.ResourceRecordSet {
+	key := recordSetKey(record.Entry, record.Type, record.SetIdentifier)
+
+	for _, recordSet := range existing {
+		if recordSetKey(*recordSet.Name, *recordSet.Type, aws.StringValue(recordSet.SetIdentifier)) == key {
+			return recordSet
+		}
+	}
+
+	return nil
+}
+
+// mergeRecord fills in the fields a record leaves unspecified from the
+// record set it replaces
+func mergeRecord(record Record, current *route53.ResourceRecordSet) Record {
+	if record.Alias == nil && record.TTL == 0 && current.TTL != nil {
+		record.TTL = *current.TTL
+	}
+	return record
+}
+
+// valuesEqual compares the values of two record sets regardless of order
+func valuesEqual(a, b []*route53.ResourceRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	av, bv := sortedValues(a), sortedValues(b)
+
+	for i := range av {
+		if av[i] != bv[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedValues(records []*route53.ResourceRecord) []string {
+	values := make([]string, len(records))
+	for i, rr := range records {
+		values[i] = aws.StringValue(rr.Value)
+	}
+
+	sort.Strings(values)
+
+	return values
+}
+
+func aliasEqual(a, b *route53.AliasTarget) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return aws.StringValue(a.HostedZoneId) == aws.StringValue(b.HostedZoneId) &&
+		strings.ToLower(entryName(aws.StringValue(a.DNSName))) == strings.ToLower(entryName(aws.StringValue(b.DNSName))) &&
+		aws.BoolValue(a.EvaluateTargetHealth) == aws.BoolValue(b.EvaluateTargetHealth)
+}
+
+func geoLocationEqual(a, b *route53.GeoLocation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return aws.StringValue(a.ContinentCode) == aws.StringValue(b.ContinentCode) &&
+		aws.StringValue(a.CountryCode) == aws.StringValue(b.CountryCode) &&
+		aws.StringValue(a.SubdivisionCode) == aws.StringValue(b.SubdivisionCode)
+}
+
+func geoProximityEqual(a, b *route53.GeoProximityLocation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	if (a.Coordinates == nil) != (b.Coordinates == nil) {
+		return false
+	}
+
+	if a.Coordinates != nil && (aws.StringValue(a.Coordinates.Latitude) != aws.StringValue(b.Coordinates.Latitude) ||
+		aws.StringValue(a.Coordinates.Longitude) != aws.StringValue(b.Coordinates.Longitude)) {
+		return false
+	}
+
+	return aws.StringValue(a.AWSRegion) == aws.StringValue(b.AWSRegion) &&
+		aws.Int64Value(a.Bias) == aws.Int64Value(b.Bias)
+}
+
+// recordSetsEqual returns true when applying the desired record set would
+// not change the current one
+func recordSetsEqual(desired, current *route53.ResourceRecordSet) bool {
+	return recordSetKey(*desired.Name, *desired.Type, aws.StringValue(desired.SetIdentifier)) ==
+		recordSetKey(*current.Name, *current.Type, aws.StringValue(current.SetIdentifier)) &&
+		aws.Int64Value(desired.TTL) == aws.Int64Value(current.TTL) &&
+		valuesEqual(desired.ResourceRecords, current.ResourceRecords) &&
+		aliasEqual(desired.AliasTarget, current.AliasTarget) &&
+		aws.Int64Value(desired.Weight) == aws.Int64Value(current.Weight) &&
+		(desired.Weight == nil) == (current.Weight == nil) &&
+		aws.StringValue(desired.Region) == aws.StringValue(current.Region) &&
+		aws.StringValue(desired.Failover) == aws.StringValue(current.Failover) &&
+		aws.StringValue(desired.HealthCheckId) == aws.StringValue(current.HealthCheckId) &&
+		geoLocationEqual(desired.GeoLocation, current.GeoLocation) &&
+		geoProximityEqual(desired.GeoProximityLocation, current.GeoProximityLocation)
+}
@@ -0,0 +1,188 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcileChanges(t *testing.T) {
+	Convey("Given a zone with existing records", t, func() {
+		e := testEvent
+		existing := []*route53.ResourceRecordSet{
+			{
+				Name:            aws.String("www.test."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}},
+			},
+			{
+				Name:            aws.String("api.test."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(3600),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}},
+			},
+		}
+
+		Convey("When a record is fully identical", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 3600},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should not emit any changes", func() {
+				So(len(changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a record lists the same values in another order", func() {
+			existing = append(existing, &route53.ResourceRecordSet{
+				Name:            aws.String("pool.test."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}, {Value: aws.String("10.0.0.4")}},
+			})
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 3600},
+				{Entry: "pool.test", Type: "A", Values: []string{"10.0.0.4", "10.0.0.3"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should not emit any changes", func() {
+				So(len(changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a reordered record also changes its values", func() {
+			existing = append(existing, &route53.ResourceRecordSet{
+				Name:            aws.String("pool.test."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}, {Value: aws.String("10.0.0.4")}},
+			})
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 3600},
+				{Entry: "pool.test", Type: "A", Values: []string{"10.0.0.5", "10.0.0.4", "10.0.0.3"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should send the values in the order given", func() {
+				So(len(changes), ShouldEqual, 1)
+				values := changes[0].ResourceRecordSet.ResourceRecords
+				So(*values[0].Value, ShouldEqual, "10.0.0.5")
+				So(*values[1].Value, ShouldEqual, "10.0.0.4")
+				So(*values[2].Value, ShouldEqual, "10.0.0.3")
+			})
+		})
+
+		Convey("When a record differs only in ttl", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 60},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 3600},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should only update the changed record", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 60)
+			})
+		})
+
+		Convey("When a record does not specify a ttl", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should preserve the existing ttl", func() {
+				So(len(changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a record changes its values", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.3"}},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should update the record keeping its existing ttl", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "api.test.")
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 3600)
+				So(*changes[0].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.3")
+			})
+		})
+	})
+}
+
+func TestReconcileWildcards(t *testing.T) {
+	Convey("Given a zone with a wildcard and a named record", t, func() {
+		e := testEvent
+		e.Name = "example.com"
+		existing := []*route53.ResourceRecordSet{
+			{
+				Name:            aws.String("\\052.example.com."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}},
+			},
+			{
+				Name:            aws.String("foo.example.com."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}},
+			},
+		}
+
+		Convey("When the event declares both records unchanged", func() {
+			e.Records = Records{
+				{Entry: "*.example.com", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "foo.example.com", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should match the escaped wildcard and not emit any changes", func() {
+				So(len(changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the event only declares the named record", func() {
+			e.Records = Records{
+				{Entry: "foo.example.com", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should only remove the wildcard", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "DELETE")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "\\052.example.com.")
+			})
+		})
+
+		Convey("When the event only declares the wildcard", func() {
+			e.Records = Records{
+				{Entry: "*.example.com", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should only remove the named record", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "DELETE")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "foo.example.com.")
+			})
+		})
+	})
+}
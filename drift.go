@@ -0,0 +1,71 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Drift stores the differences between the records of an event and its
+// live zone
+type Drift struct {
+	Added   Records        `json:"added"`
+	Removed Records        `json:"removed"`
+	Changed []RecordChange `json:"changed"`
+}
+
+// RecordChange stores a record as it is in the zone and as the event wants it
+type RecordChange struct {
+	Current Record `json:"current"`
+	Desired Record `json:"desired"`
+}
+
+// diffRoute53 reports the changes an update would make to the zone, without
+// making any of them
+func diffRoute53(ev *Event) error {
+	if err := resolveZoneID(ev); err != nil {
+		return err
+	}
+
+	zr, err := getZoneRecords(ev)
+	if err != nil {
+		return err
+	}
+
+	drift := &Drift{}
+
+	for _, change := range buildChanges(ev, zr) {
+		recordSet := change.ResourceRecordSet
+
+		// the manifest is bookkeeping of the connector, not drift
+		if isManifest(ev, recordSet) {
+			continue
+		}
+
+		if *change.Action == "DELETE" {
+			drift.Removed = append(drift.Removed, recordsFromResourceRecordSets([]*route53.ResourceRecordSet{recordSet})...)
+			continue
+		}
+
+		current := findRecordSet(zr, Record{
+			Entry:         *recordSet.Name,
+			Type:          *recordSet.Type,
+			SetIdentifier: aws.StringValue(recordSet.SetIdentifier),
+		})
+
+		if current == nil {
+			drift.Added = append(drift.Added, recordsFromResourceRecordSets([]*route53.ResourceRecordSet{recordSet})...)
+			continue
+		}
+
+		records := recordsFromResourceRecordSets([]*route53.ResourceRecordSet{current, recordSet})
+		drift.Changed = append(drift.Changed, RecordChange{Current: records[0], Desired: records[1]})
+	}
+
+	ev.Drift = drift
+
+	return nil
+}
@@ -0,0 +1,65 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiffRoute53(t *testing.T) {
+	Convey("Given a zone that has drifted from its event", t, func() {
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test.")},
+			},
+			records: []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.test.")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("api.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}}},
+				{Name: aws.String("old.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}}},
+			},
+			pageSize: 2,
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+		e.Records = Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+			{Entry: "new.test", Type: "A", Values: []string{"10.0.0.4"}, TTL: 300},
+		}
+
+		Convey("When diffing the zone", func() {
+			err := diffRoute53(&e)
+
+			Convey("It should report the changed ttl", func() {
+				So(err, ShouldBeNil)
+				So(len(e.Drift.Changed), ShouldEqual, 1)
+				So(e.Drift.Changed[0].Current.Entry, ShouldEqual, "www.test")
+				So(e.Drift.Changed[0].Current.TTL, ShouldEqual, 60)
+				So(e.Drift.Changed[0].Desired.TTL, ShouldEqual, 300)
+			})
+
+			Convey("It should report the added and removed records", func() {
+				So(len(e.Drift.Added), ShouldEqual, 1)
+				So(e.Drift.Added[0].Entry, ShouldEqual, "new.test")
+				So(len(e.Drift.Removed), ShouldEqual, 1)
+				So(e.Drift.Removed[0].Entry, ShouldEqual, "old.test")
+			})
+
+			Convey("It should not change the zone", func() {
+				So(len(c.changes), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -7,8 +7,16 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
 )
 
 var (
@@ -16,101 +24,1060 @@ var (
 	ErrDatacenterIDInvalid = errors.New("Datacenter VPC ID invalid")
 	// ErrDatacenterRegionInvalid : error for datacenter revgion invalid
 	ErrDatacenterRegionInvalid = errors.New("Datacenter Region invalid")
+	// ErrVPCRegionInvalid : error for private zone vpc region invalid
+	ErrVPCRegionInvalid = errors.New("Route53 private zone VPC region invalid")
 	// ErrDatacenterCredentialsInvalid : error for datacenter credentials invalid
 	ErrDatacenterCredentialsInvalid = errors.New("Datacenter credentials invalid")
 	// ErrZoneNameInvalid : error for zone name invalid
 	ErrZoneNameInvalid = errors.New("Route53 zone name invalid")
+	// ErrRecordsEmpty : error for a zone created without records
+	ErrRecordsEmpty = errors.New("Route53 zone records empty")
+	// ErrCallerReferenceInvalid : error for caller reference invalid
+	ErrCallerReferenceInvalid = errors.New("Route53 caller reference must be at most 128 characters")
+	// ErrChangeCommentInvalid : error for change comment invalid
+	ErrChangeCommentInvalid = errors.New("Route53 change comment must be at most 256 characters")
+	// ErrChangeTimeout : error for a change not reaching INSYNC in time
+	ErrChangeTimeout = errors.New("Route53 change did not reach INSYNC before the timeout")
+	// ErrResolverRuleIDInvalid : error for resolver rule id invalid
+	ErrResolverRuleIDInvalid = errors.New("Route53 resolver rule ID invalid")
+	// ErrHostedZoneNotFound : error for no hosted zone matching the event
+	ErrHostedZoneNotFound = errors.New("Route53 hosted zone not found")
+	// ErrHostedZoneIDRequired : error for a missing hosted zone id on upsert
+	ErrHostedZoneIDRequired = errors.New("Route53 hosted zone ID required")
+	// ErrSubjectInvalid : error for a subject without a resource, action and provider
+	ErrSubjectInvalid = errors.New("Route53 event subject invalid")
+	// ErrConcurrentModification : error for a zone that kept changing while its changes were applied
+	ErrConcurrentModification = errors.New("Route53 zone was modified concurrently, too many retries")
 )
 
+var vpcIDPattern = regexp.MustCompile(`^vpc-[0-9a-f]+$`)
+
+// maxVPCAssociations is the most vpcs a private zone can be associated with
+var maxVPCAssociations = 300
+
+// maxRecords is the most records a hosted zone can hold
+var maxRecords = 10000
+
+// maxEventRecords is the most records, across all zones, a single event can
+// create, update or delete
+var maxEventRecords = 10000
+
+// minRecordTTL and maxRecordTTL bound the ttl a record can be given, a
+// record without one takes the default ttl
+var minRecordTTL int64 = 1
+var maxRecordTTL int64 = 604800
+
+// allowedZones are the name suffixes of the zones the connector may manage,
+// any zone when empty
+var allowedZones []string
+
+// health check ids are uuids
+var healthCheckIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+var dnsLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// record labels may also start with an underscore, as with srv and dkim names
+var entryLabelPattern = regexp.MustCompile(`^_?[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// Publisher sends event messages to a subject
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
 // Records stores a collection of records
 type Records []Record
 
 // Record stores the entries for a zone
 type Record struct {
-	Entry  string   `json:"entry"`
-	Type   string   `json:"type"`
-	Values []string `json:"values"`
-	TTL    int64    `json:"ttl"`
+	Entry         string        `json:"entry"`
+	Type          string        `json:"type"`
+	Values        []string      `json:"values"`
+	TTL           int64         `json:"ttl"`
+	Alias         *Alias        `json:"alias,omitempty"`
+	SetIdentifier string        `json:"set_identifier,omitempty"`
+	Weight        *int64        `json:"weight,omitempty"`
+	Region        string        `json:"region,omitempty"`
+	Failover      string        `json:"failover,omitempty"`
+	GeoLocation   *GeoLocation  `json:"geo_location,omitempty"`
+	GeoProximity  *GeoProximity `json:"geo_proximity,omitempty"`
+	HealthCheckID string        `json:"health_check_id,omitempty"`
+	// only read to reject records evaluating target health outside an alias
+	EvaluateTargetHealth *bool `json:"evaluate_target_health,omitempty"`
+}
+
+// Alias stores the target of an alias record
+type Alias struct {
+	HostedZoneID         string `json:"hosted_zone_id"`
+	DNSName              string `json:"dns_name"`
+	EvaluateTargetHealth bool   `json:"evaluate_target_health"`
+}
+
+// GeoLocation stores the location a geolocation record answers for
+type GeoLocation struct {
+	ContinentCode   string `json:"continent_code,omitempty"`
+	CountryCode     string `json:"country_code,omitempty"`
+	SubdivisionCode string `json:"subdivision_code,omitempty"`
+}
+
+// GeoProximity stores the location a geoproximity record answers for,
+// either an aws region or coordinates, and the bias that grows or shrinks
+// the area routed to it
+type GeoProximity struct {
+	AWSRegion string `json:"aws_region,omitempty"`
+	Latitude  string `json:"latitude,omitempty"`
+	Longitude string `json:"longitude,omitempty"`
+	Bias      int64  `json:"bias,omitempty"`
+}
+
+// SOA stores the timers of the apex SOA record, the primary nameserver and
+// contact are kept as route53 assigned them
+type SOA struct {
+	Refresh    int64 `json:"refresh"`
+	Retry      int64 `json:"retry"`
+	Expire     int64 `json:"expire"`
+	MinimumTTL int64 `json:"minimum_ttl"`
+}
+
+// Validate checks the SOA timers are in range
+func (s *SOA) Validate() error {
+	fields := []struct {
+		name  string
+		value int64
+	}{
+		{"refresh", s.Refresh},
+		{"retry", s.Retry},
+		{"expire", s.Expire},
+		{"minimum_ttl", s.MinimumTTL},
+	}
+
+	for _, f := range fields {
+		if f.value < 1 || f.value > 2147483647 {
+			return fmt.Errorf("Route53 SOA %s %d must be between 1 and 2147483647", f.name, f.value)
+		}
+	}
+
+	return nil
+}
+
+// VPC stores an additional vpc associated with a private zone
+type VPC struct {
+	VPCID     string `json:"vpc_id"`
+	VPCRegion string `json:"vpc_region,omitempty"`
+	// credentials of the account owning the vpc, when it is not the zone's
+	DatacenterSecret string `json:"datacenter_secret,omitempty"`
+	DatacenterToken  string `json:"datacenter_token,omitempty"`
+}
+
+// crossAccount returns true for a vpc owned by another account than the zone
+func (v VPC) crossAccount() bool {
+	return v.DatacenterSecret != "" || v.DatacenterToken != ""
+}
+
+// key returns the vpc without its credentials, to compare it with the vpcs
+// a zone is associated with
+func (v VPC) key() VPC {
+	return VPC{VPCID: v.VPCID, VPCRegion: v.VPCRegion}
+}
+
+// Zone stores a hosted zone managed alongside others in a single event
+type Zone struct {
+	HostedZoneID        string   `json:"hosted_zone_id"`
+	Name                string   `json:"name"`
+	Private             bool     `json:"private"`
+	VPCID               string   `json:"vpc_id,omitempty"`
+	Records             Records  `json:"records"`
+	SkippedRecords      []string `json:"skipped_records,omitempty"`
+	RecordSetCount      int      `json:"record_set_count,omitempty"`
+	ResourceRecordCount int      `json:"resource_record_count,omitempty"`
+	ErrorMessage        string   `json:"error_message,omitempty"`
+}
+
+// Result stores the outcome of applying an event, reported along with it
+// on its done or error message
+type Result struct {
+	NameServers         []string `json:"name_servers,omitempty"`
+	AlreadyDeleted      bool     `json:"already_deleted,omitempty"`
+	SkippedRecords      []string `json:"skipped_records,omitempty"`
+	AppliedBatches      int      `json:"applied_batches,omitempty"`
+	FailedBatch         int      `json:"failed_batch,omitempty"`
+	ChangeCount         int      `json:"change_count,omitempty"`
+	ChangeIDs           []string `json:"change_ids,omitempty"`
+	DurationMs          int64    `json:"duration_ms,omitempty"`
+	Coalesced           int      `json:"coalesced,omitempty"`
+	RecordSetCount      int      `json:"record_set_count,omitempty"`
+	ResourceRecordCount int      `json:"resource_record_count,omitempty"`
+	RemovedRecords      Records  `json:"removed_records,omitempty"`
 }
 
 // Event stores the route53 data
 type Event struct {
-	UUID             string  `json:"_uuid"`
-	BatchID          string  `json:"_batch_id"`
-	ProviderType     string  `json:"_type"`
-	HostedZoneID     string  `json:"hosted_zone_id"`
-	Name             string  `json:"name"`
-	Private          bool    `json:"private"`
-	Records          Records `json:"records"`
-	VPCID            string  `json:"vpc_id"`
-	DatacenterName   string  `json:"datacenter_name,omitempty"`
-	DatacenterRegion string  `json:"datacenter_region"`
-	DatacenterToken  string  `json:"datacenter_token"`
-	DatacenterSecret string  `json:"datacenter_secret"`
-	ErrorMessage     string  `json:"error_message,omitempty"`
+	UUID                    string            `json:"_uuid"`
+	BatchID                 string            `json:"_batch_id"`
+	ProviderType            string            `json:"_type"`
+	HostedZoneID            string            `json:"hosted_zone_id"`
+	CallerReference         string            `json:"caller_reference,omitempty"`
+	Comment                 string            `json:"comment,omitempty"`
+	ChangeComment           string            `json:"change_comment,omitempty"`
+	Tags                    map[string]string `json:"tags,omitempty"`
+	Name                    string            `json:"name"`
+	Private                 bool              `json:"private"`
+	Records                 Records           `json:"records"`
+	RecordsToDelete         Records           `json:"records_to_delete,omitempty"`
+	ZoneFile                string            `json:"zone_file,omitempty"`
+	ProtectedRecords        []string          `json:"protected_records,omitempty"`
+	OwnedHealthChecks       []string          `json:"owned_health_checks,omitempty"`
+	Zones                   []Zone            `json:"zones,omitempty"`
+	ListedZones             []ZoneSummary     `json:"listed_zones,omitempty"`
+	ManageDefaultRecords    bool              `json:"manage_default_records"`
+	AllowEmptyZone          bool              `json:"allow_empty_zone"`
+	ConfirmEmpty            bool              `json:"confirm_empty"`
+	OwnershipManifest       bool              `json:"ownership_manifest"`
+	AppendOnly              bool              `json:"append_only"`
+	Replace                 bool              `json:"replace"`
+	Targeted                bool              `json:"targeted"`
+	DefaultTTL              int64             `json:"default_ttl,omitempty"`
+	MaxTTL                  int64             `json:"max_ttl,omitempty"`
+	SOA                     *SOA              `json:"soa,omitempty"`
+	WaitForSync             bool              `json:"wait_for_sync"`
+	VPCID                   string            `json:"vpc_id"`
+	VPCRegion               string            `json:"vpc_region,omitempty"`
+	VPCs                    []VPC             `json:"vpcs,omitempty"`
+	ResolverRuleID          string            `json:"resolver_rule_id,omitempty"`
+	ResolverAssociationID   string            `json:"resolver_rule_association_id,omitempty"`
+	TrafficPolicyID         string            `json:"traffic_policy_id,omitempty"`
+	TrafficPolicyVersion    int64             `json:"traffic_policy_version,omitempty"`
+	TrafficPolicyRecord     string            `json:"traffic_policy_record,omitempty"`
+	TrafficPolicyTTL        int64             `json:"traffic_policy_ttl,omitempty"`
+	TrafficPolicyInstanceID string            `json:"traffic_policy_instance_id,omitempty"`
+	DatacenterName          string            `json:"datacenter_name,omitempty"`
+	DatacenterRegion        string            `json:"datacenter_region"`
+	Partition               string            `json:"partition,omitempty"`
+	DatacenterToken         string            `json:"datacenter_token"`
+	DatacenterSecret        string            `json:"datacenter_secret"`
+	ReadDatacenterToken     string            `json:"read_datacenter_token,omitempty"`
+	ReadDatacenterSecret    string            `json:"read_datacenter_secret,omitempty"`
+	VerifyCredentials       bool              `json:"verify_credentials"`
+	Debug                   bool              `json:"debug"`
+	Result
+	ErrorMessage     string   `json:"error_message,omitempty"`
+	ValidationErrors []string `json:"validation_errors,omitempty"`
+	ExistingRecords  Records  `json:"existing_records,omitempty"`
+	Drift            *Drift   `json:"drift,omitempty"`
+	RequestID        string   `json:"request_id,omitempty"`
+	resource         string
 	action           string
+	budget           *retryBudget
+	publisher        Publisher
 }
 
 func entryName(entry string) string {
-	if string(entry[len(entry)-1]) == "." {
+	// route53 lists wildcard labels in their escaped octal form
+	entry = strings.Replace(entry, `\052`, "*", -1)
+
+	if entry != "" && string(entry[len(entry)-1]) == "." {
 		return entry[:len(entry)-1]
 	}
 	return entry
 }
 
+// canonicalName returns the entry as route53 stores it, with a trailing dot
+func canonicalName(entry string) string {
+	return entryName(entry) + "."
+}
+
+// zoneName returns the name of a zone in lower case, without a trailing dot
+func zoneName(name string) string {
+	return strings.ToLower(entryName(name))
+}
+
+// sameName returns true when two names are the same dns name, with or
+// without a trailing dot and in any case
+func sameName(a, b string) bool {
+	return strings.EqualFold(entryName(a), entryName(b))
+}
+
 // HasRecord returns true if a matched entry is found
 func (r Records) HasRecord(entry string) bool {
 	// check with removed . character as well
 	for _, record := range r {
-		if entryName(record.Entry) == entryName(entry) {
+		if sameName(record.Entry, entry) {
 			return true
 		}
 	}
 	return false
 }
 
-// Validate checks if all criteria are met
+// HasRecordSet returns true if a record matches the name, type and set
+// identifier of the record set
+func (r Records) HasRecordSet(recordSet *route53.ResourceRecordSet) bool {
+	for _, record := range r {
+		if sameName(record.Entry, *recordSet.Name) &&
+			record.Type == *recordSet.Type &&
+			record.SetIdentifier == aws.StringValue(recordSet.SetIdentifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the record values are well formed for its type
+// and that the record is allowed in the given zone
+func (r *Record) Validate(zone string) error {
+	if r.Entry == "" {
+		return errors.New("Record entry can not be empty")
+	}
+
+	if err := validateEntryName(r.Entry); err != nil {
+		return fmt.Errorf("Record %s has an invalid entry: %s", r.Entry, err.Error())
+	}
+
+	if r.Type == "CNAME" && sameName(r.Entry, zone) {
+		return fmt.Errorf("Record %s can not be a CNAME at the zone apex, use an alias A record instead", r.Entry)
+	}
+
+	if r.EvaluateTargetHealth != nil {
+		return fmt.Errorf("Record %s can only evaluate target health as an alias, set evaluate_target_health on its alias", r.Entry)
+	}
+
+	if r.HealthCheckID != "" {
+		if r.Alias != nil {
+			return fmt.Errorf("Record %s is an alias, use evaluate_target_health instead of a health check", r.Entry)
+		}
+
+		if healthCheckIDPattern.MatchString(r.HealthCheckID) != true {
+			return fmt.Errorf("Record %s has an invalid health check id '%s'", r.Entry, r.HealthCheckID)
+		}
+	}
+
+	// an alias answers with its target's records, but it can still take
+	// part in a routing policy
+	if r.Alias != nil && (r.TTL != 0 || len(r.Values) > 0) {
+		return fmt.Errorf("Record %s is an alias and can not have a ttl or values", r.Entry)
+	}
+
+	if r.Alias == nil && r.TTL != 0 && (r.TTL < minRecordTTL || r.TTL > maxRecordTTL) {
+		return fmt.Errorf("Record %s has an invalid ttl %d, must be between %d and %d", r.Entry, r.TTL, minRecordTTL, maxRecordTTL)
+	}
+
+	policies := r.routingPolicies()
+	if len(policies) > 1 {
+		return fmt.Errorf("Record %s can only have one routing policy, got %s", r.Entry, strings.Join(policies, " and "))
+	}
+
+	// route53 tells the record sets of a routing policy apart by their set id
+	if len(policies) == 1 && r.SetIdentifier == "" {
+		return fmt.Errorf("Record %s has a %s routing policy and requires a set identifier", r.Entry, policies[0])
+	}
+
+	if r.GeoProximity != nil {
+		if err := r.GeoProximity.validate(r.Entry); err != nil {
+			return err
+		}
+	}
+
+	if r.Type == "SPF" {
+		logf(levelWarn, "Warning: record %s uses the deprecated SPF type, publish it as a TXT record instead", r.Entry)
+	}
+
+	switch r.Type {
+	case "A", "AAAA":
+		return r.validateAddresses()
+	case "MX":
+		return r.validateNumericFields("priority")
+	case "SRV":
+		return r.validateNumericFields("priority", "weight", "port")
+	case "NAPTR":
+		return r.validateNAPTR()
+	}
+	return nil
+}
+
+// routingPolicies returns the routing policies the record specifies
+func (r *Record) routingPolicies() []string {
+	var policies []string
+
+	if r.Weight != nil {
+		policies = append(policies, "weight")
+	}
+
+	if r.Region != "" {
+		policies = append(policies, "region")
+	}
+
+	if r.Failover != "" {
+		policies = append(policies, "failover")
+	}
+
+	if r.GeoLocation != nil {
+		policies = append(policies, "geo_location")
+	}
+
+	if r.GeoProximity != nil {
+		policies = append(policies, "geo_proximity")
+	}
+
+	return policies
+}
+
+// validate checks the geoproximity has exactly one location and a bias in
+// the range route53 accepts
+func (g *GeoProximity) validate(entry string) error {
+	if g.Bias < -99 || g.Bias > 99 {
+		return fmt.Errorf("Record %s has an invalid geo_proximity bias %d, must be between -99 and 99", entry, g.Bias)
+	}
+
+	coordinates := g.Latitude != "" || g.Longitude != ""
+
+	if (g.AWSRegion != "") == coordinates {
+		return fmt.Errorf("Record %s geo_proximity requires either an aws_region or coordinates", entry)
+	}
+
+	if !coordinates {
+		return nil
+	}
+
+	if lat, err := strconv.ParseFloat(g.Latitude, 64); err != nil || lat < -90 || lat > 90 {
+		return fmt.Errorf("Record %s has an invalid geo_proximity latitude '%s', must be between -90 and 90", entry, g.Latitude)
+	}
+
+	if long, err := strconv.ParseFloat(g.Longitude, 64); err != nil || long < -180 || long > 180 {
+		return fmt.Errorf("Record %s has an invalid geo_proximity longitude '%s', must be between -180 and 180", entry, g.Longitude)
+	}
+
+	return nil
+}
+
+// validateDelete checks the record has enough detail for route53 to match
+// the record set being deleted
+func (r *Record) validateDelete() error {
+	if r.Entry == "" || r.Type == "" {
+		return errors.New("Record to delete requires an entry and type")
+	}
+
+	if r.Alias == nil && (len(r.Values) == 0 || r.TTL == 0) {
+		return fmt.Errorf("Record %s to delete requires its values and ttl", r.Entry)
+	}
+
+	return nil
+}
+
+// validateAddresses checks the values of an A or AAAA record are ipv4 or
+// ipv6 addresses respectively
+func (r *Record) validateAddresses() error {
+	// aliases have no values of their own
+	if r.Alias != nil {
+		return nil
+	}
+
+	for _, v := range r.Values {
+		ip := net.ParseIP(strings.TrimSpace(v))
+
+		switch {
+		case r.Type == "A" && (ip == nil || ip.To4() == nil):
+			return fmt.Errorf("Record %s has an invalid A value '%s', must be an ipv4 address", r.Entry, v)
+		case r.Type == "AAAA" && (ip == nil || !strings.Contains(v, ":")):
+			return fmt.Errorf("Record %s has an invalid AAAA value '%s', must be an ipv6 address", r.Entry, v)
+		case r.Type == "AAAA" && ip.To4() != nil:
+			// an ipv4 mapped address renders as ipv4, which route53 rejects
+			return fmt.Errorf("Record %s has an invalid AAAA value '%s', must not be an ipv4 mapped address", r.Entry, v)
+		}
+	}
+
+	return nil
+}
+
+// validateNumericFields checks each value is made up of the given 16 bit
+// numeric fields followed by a target
+func (r *Record) validateNumericFields(fields ...string) error {
+	for _, v := range r.Values {
+		parts := strings.Fields(v)
+		if len(parts) != len(fields)+1 {
+			return fmt.Errorf("Record %s has an invalid %s value '%s'", r.Entry, r.Type, v)
+		}
+
+		for i, field := range fields {
+			if _, err := strconv.ParseUint(parts[i], 10, 16); err != nil {
+				return fmt.Errorf("Record %s has an invalid %s %s '%s', must be between 0 and 65535", r.Entry, r.Type, field, parts[i])
+			}
+		}
+	}
+	return nil
+}
+
+// validateNAPTR checks each value is in the form
+// order preference "flags" "service" "regexp" replacement
+func (r *Record) validateNAPTR() error {
+	for _, v := range r.Values {
+		parts := splitQuotedFields(v)
+		if len(parts) != 6 {
+			return fmt.Errorf("Record %s has an invalid NAPTR value '%s'", r.Entry, v)
+		}
+
+		for i, field := range []string{"order", "preference"} {
+			if _, err := strconv.ParseUint(parts[i], 10, 16); err != nil {
+				return fmt.Errorf("Record %s has an invalid NAPTR %s '%s', must be between 0 and 65535", r.Entry, field, parts[i])
+			}
+		}
+
+		for i, field := range []string{"flags", "service", "regexp"} {
+			p := parts[i+2]
+			if len(p) < 2 || !strings.HasPrefix(p, `"`) || !strings.HasSuffix(p, `"`) {
+				return fmt.Errorf("Record %s has an invalid NAPTR %s '%s', must be quoted", r.Entry, field, p)
+			}
+		}
+
+		if parts[4] != `""` && parts[5] != "." {
+			return fmt.Errorf("Record %s has an invalid NAPTR value '%s', regexp and replacement can not both be set", r.Entry, v)
+		}
+	}
+	return nil
+}
+
+// splitQuotedFields splits a value on whitespace, keeping quoted strings whole
+func splitQuotedFields(value string) []string {
+	var fields []string
+	var field []byte
+	var quoted, escaped bool
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if len(field) > 0 {
+				fields = append(fields, string(field))
+				field = nil
+			}
+			continue
+		}
+
+		field = append(field, c)
+	}
+
+	if len(field) > 0 {
+		fields = append(fields, string(field))
+	}
+
+	return fields
+}
+
+// validateDNSName checks a name against the dns length and label rules
+func validateDNSName(name string) error {
+	return validateName(name, dnsLabelPattern)
+}
+
+// validateEntryName checks a record entry against the dns length and label
+// rules, allowing underscore prefixed labels
+func validateEntryName(name string) error {
+	return validateName(name, entryLabelPattern)
+}
+
+func validateName(name string, labelPattern *regexp.Regexp) error {
+	name = entryName(name)
+
+	if len(name) > 255 {
+		return errors.New("name exceeds 255 characters")
+	}
+
+	for i, label := range strings.Split(name, ".") {
+		if label == "" {
+			return errors.New("name contains an empty label")
+		}
+
+		// a wildcard can only be the leftmost label
+		if label == "*" && i == 0 {
+			continue
+		}
+
+		if len(label) > 63 {
+			return fmt.Errorf("label %s exceeds 63 characters", label)
+		}
+
+		if labelPattern.MatchString(label) != true {
+			return fmt.Errorf("label %s contains invalid characters", label)
+		}
+	}
+
+	return nil
+}
+
+// ErrorList collects every problem found validating an event
+type ErrorList []error
+
+func (errs ErrorList) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	return fmt.Sprintf("Route53 event has %d validation errors: %s", len(errs), strings.Join(errs.messages(), "; "))
+}
+
+// messages returns the message of each problem
+func (errs ErrorList) messages() []string {
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+// Validate checks if all criteria are met, returning the first problem found
 func (ev *Event) Validate() error {
-	if ev.VPCID == "" {
+	if errs := ev.validationErrors(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidateAll checks if all criteria are met, returning every problem found
+// as an ErrorList
+func (ev *Event) ValidateAll() error {
+	if errs := ev.validationErrors(); len(errs) > 0 {
+		return ErrorList(errs)
+	}
+	return nil
+}
+
+// validationErrors returns every problem with the event, the first being
+// the one Validate reports
+func (ev *Event) validationErrors() []error {
+	// reject oversized events before looking at any of their records
+	if n := ev.recordCount(); n > maxEventRecords {
+		return []error{fmt.Errorf("Route53 event can have at most %d records, %d given", maxEventRecords, n)}
+	}
+
+	if len(ev.Zones) > 0 {
+		return ev.validateZones()
+	}
+
+	errs := ev.validateZone()
+
+	if ev.resource == "route53_resolver" {
+		return errs
+	}
+
+	errs = append(errs, ev.validateAllowed()...)
+
+	for _, record := range ev.Records {
+		if err := record.Validate(ev.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, record := range ev.RecordsToDelete {
+		if err := record.validateDelete(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, id := range ev.OwnedHealthChecks {
+		if healthCheckIDPattern.MatchString(id) != true {
+			errs = append(errs, fmt.Errorf("Route53 owned health check id '%s' is invalid", id))
+		}
+	}
+
+	if ev.Targeted {
+		if err := ev.validateTargeted(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// validateZone checks the event's zone and credentials
+func (ev *Event) validateZone() []error {
+	var errs []error
+
+	// only private zones are associated with a vpc
+	if ev.Private && !ev.reads() && ev.action != "list" {
+		if err := ev.validateVPCs(); err != nil {
+			errs = append(errs, err)
+		}
+	} else if !ev.Private {
+		for _, vpc := range ev.VPCs {
+			if vpc.crossAccount() {
+				errs = append(errs, fmt.Errorf("Route53 vpc %s of another account can only be associated with a private zone", vpc.VPCID))
+			}
+		}
+	}
+
+	if ev.DatacenterSecret == "" || ev.DatacenterToken == "" {
+		errs = append(errs, ErrDatacenterCredentialsInvalid)
+	} else if (ev.ReadDatacenterSecret == "") != (ev.ReadDatacenterToken == "") {
+		// read credentials are optional, but need both parts when supplied
+		errs = append(errs, ErrDatacenterCredentialsInvalid)
+	}
+
+	if ev.Partition != "" {
+		if err := ev.validatePartition(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ev.resource == "route53_resolver" {
+		if err := ev.validateResolver(); err != nil {
+			errs = append(errs, err)
+		}
+		return errs
+	}
+
+	// listing reads the whole account rather than a zone
+	if ev.action == "list" {
+		return errs
+	}
+
+	// a zone can be read by its id alone
+	if ev.Name == "" && (!ev.reads() || ev.HostedZoneID == "") {
+		errs = append(errs, ErrZoneNameInvalid)
+	}
+
+	if ev.Name != "" {
+		if err := validateDNSName(ev.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", ErrZoneNameInvalid.Error(), err.Error()))
+		}
+	}
+
+	if ev.SOA != nil {
+		if err := ev.SOA.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ev.TrafficPolicyID != "" {
+		if err := ev.validateTrafficPolicy(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := ev.validateTags(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if ev.Replace && ev.AppendOnly {
+		errs = append(errs, errors.New("Route53 zone can not be replaced in append only mode"))
+	}
+
+	if len(ev.CallerReference) > 128 {
+		errs = append(errs, ErrCallerReferenceInvalid)
+	}
+
+	if len(ev.ChangeComment) > 256 {
+		errs = append(errs, ErrChangeCommentInvalid)
+	}
+
+	// records are cleared on delete, but a zone without any is likely a mistake
+	if ev.action == "create" && len(ev.Records) == 0 && ev.AllowEmptyZone != true {
+		errs = append(errs, ErrRecordsEmpty)
+	}
+
+	if ev.action == "import" {
+		if err := ev.validateZoneFile(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// upserts change a known zone without reading it
+	if ev.action == "upsert" {
+		if ev.HostedZoneID == "" {
+			errs = append(errs, ErrHostedZoneIDRequired)
+		}
+		if len(ev.Records) == 0 {
+			errs = append(errs, ErrRecordsEmpty)
+		}
+	}
+
+	// fail before a bulk create leaves a partial zone behind
+	if len(ev.Records) > maxRecords {
+		errs = append(errs, fmt.Errorf("Route53 zone can hold at most %d records, %d requested", maxRecords, len(ev.Records)))
+	}
+
+	return errs
+}
+
+// validateTargeted checks a targeted update identifies each record set in
+// full, as the zone is not read to fill in what is missing
+func (ev *Event) validateTargeted() error {
+	if ev.action != "update" {
+		return errors.New("Route53 targeted changes are only supported on update")
+	}
+
+	for _, record := range ev.Records {
+		if record.SetIdentifier == "" {
+			return fmt.Errorf("Record %s requires a set identifier to be updated on its own", record.Entry)
+		}
+
+		if record.Alias == nil && record.TTL == 0 {
+			return fmt.Errorf("Record %s requires a ttl to be updated on its own", record.Entry)
+		}
+	}
+
+	return nil
+}
+
+// forZone returns a copy of the event that applies to a single zone
+func (ev *Event) forZone(z *Zone) Event {
+	zev := *ev
+	zev.Zones = nil
+	zev.HostedZoneID = z.HostedZoneID
+	zev.Name = z.Name
+	zev.Private = z.Private
+	zev.Records = z.Records
+	zev.Result = Result{}
+
+	if z.VPCID != "" {
+		zev.VPCID = z.VPCID
+	}
+
+	// each zone needs its own caller reference, a zone without a name is
+	// rejected by validation
+	if ev.CallerReference != "" && z.Name != "" {
+		zev.CallerReference = ev.CallerReference + "-" + zoneName(z.Name)
+	}
+
+	return zev
+}
+
+// parseAllowedZones reads a comma separated list of zone name suffixes
+func parseAllowedZones(value string) []string {
+	var suffixes []string
+
+	for _, suffix := range strings.Split(value, ",") {
+		suffix = strings.ToLower(strings.Trim(strings.TrimSpace(suffix), "."))
+		if suffix != "" {
+			suffixes = append(suffixes, "."+suffix)
+		}
+	}
+
+	return suffixes
+}
+
+// isAllowedName returns true for names within the allowed zones, a suffix
+// of .example.com allows example.com itself and any name below it
+func isAllowedName(name string) bool {
+	if len(allowedZones) == 0 {
+		return true
+	}
+
+	name = "." + strings.ToLower(entryName(name))
+
+	for _, suffix := range allowedZones {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateAllowed checks the zone and the entries of its records are within
+// the allowed zones
+func (ev *Event) validateAllowed() []error {
+	var errs []error
+
+	if ev.Name != "" && !isAllowedName(ev.Name) {
+		errs = append(errs, fmt.Errorf("Route53 zone %s is not in the zones the connector may manage", ev.Name))
+	}
+
+	for _, records := range []Records{ev.Records, ev.RecordsToDelete} {
+		for _, record := range records {
+			if record.Entry != "" && !isAllowedName(record.Entry) {
+				errs = append(errs, fmt.Errorf("Record %s is not in the zones the connector may manage", record.Entry))
+			}
+		}
+	}
+
+	return errs
+}
+
+// changeComment returns the comment of the event's change batches, which
+// defaults to the event's uuid and batch id so route53's change history can
+// be traced back to the event
+func (ev *Event) changeComment() string {
+	if ev.ChangeComment != "" {
+		return ev.ChangeComment
+	}
+	return fmt.Sprintf("event %s of batch %s", ev.UUID, ev.BatchID)
+}
+
+// vpcRegion returns the region of the private zone vpc, which defaults to the
+// datacenter region
+func (ev *Event) vpcRegion() string {
+	if ev.VPCRegion != "" {
+		return ev.VPCRegion
+	}
+	return ev.DatacenterRegion
+}
+
+// vpcs returns every vpc the private zone is associated with, the event's
+// vpc first
+func (ev *Event) vpcs() []VPC {
+	var vpcs []VPC
+
+	if ev.VPCID != "" {
+		vpcs = append(vpcs, VPC{VPCID: ev.VPCID, VPCRegion: ev.vpcRegion()})
+	}
+
+	for _, vpc := range ev.VPCs {
+		if vpc.VPCRegion == "" {
+			vpc.VPCRegion = ev.DatacenterRegion
+		}
+		vpcs = append(vpcs, vpc)
+	}
+
+	return vpcs
+}
+
+// reads returns true for actions that only read an existing zone
+func (ev *Event) reads() bool {
+	return ev.action == "get" || ev.action == "export"
+}
+
+func (ev *Event) getPublisher() Publisher {
+	if ev.publisher == nil {
+		return nc
+	}
+	return ev.publisher
+}
+
+// validateVPCs checks the vpcs a private zone is associated with
+func (ev *Event) validateVPCs() error {
+	vpcs := ev.vpcs()
+
+	if len(vpcs) == 0 {
 		return ErrDatacenterIDInvalid
 	}
 
+	// reject before associating any vpc, rather than leaving the zone
+	// partially associated
+	if len(vpcs) > maxVPCAssociations {
+		return fmt.Errorf("Route53 private zone can be associated with at most %d vpcs, %d requested", maxVPCAssociations, len(vpcs))
+	}
+
+	for _, vpc := range vpcs {
+		if vpcIDPattern.MatchString(vpc.VPCID) != true {
+			return ErrDatacenterIDInvalid
+		}
+
+		if vpc.VPCRegion == "" {
+			return ErrVPCRegionInvalid
+		}
+
+		if vpc.crossAccount() && (vpc.DatacenterSecret == "" || vpc.DatacenterToken == "") {
+			return ErrDatacenterCredentialsInvalid
+		}
+	}
+
+	// the zone is created with its first vpc, which has to be in its account
+	if vpcs[0].crossAccount() {
+		return fmt.Errorf("Route53 private zone must be created with a vpc of its own account, %s is of another account", vpcs[0].VPCID)
+	}
+
+	return nil
+}
+
+// validateResolver checks a resolver rule association event
+func (ev *Event) validateResolver() error {
 	if ev.DatacenterRegion == "" {
 		return ErrDatacenterRegionInvalid
 	}
 
-	if ev.DatacenterSecret == "" || ev.DatacenterToken == "" {
-		return ErrDatacenterCredentialsInvalid
+	if vpcIDPattern.MatchString(ev.VPCID) != true {
+		return ErrDatacenterIDInvalid
 	}
 
-	if ev.Name == "" {
-		return ErrZoneNameInvalid
+	if ev.ResolverRuleID == "" {
+		return ErrResolverRuleIDInvalid
 	}
 
 	return nil
 }
 
+// recordCount returns the number of records and records to delete of the
+// event and all of its zones
+func (ev *Event) recordCount() int {
+	n := len(ev.Records) + len(ev.RecordsToDelete)
+	for _, z := range ev.Zones {
+		n += len(z.Records)
+	}
+	return n
+}
+
+// validateZones returns every problem of each zone of the event
+func (ev *Event) validateZones() []error {
+	var errs []error
+
+	for i := range ev.Zones {
+		zev := ev.forZone(&ev.Zones[i])
+		for _, err := range zev.validationErrors() {
+			errs = append(errs, fmt.Errorf("Zone %s: %s", ev.Zones[i].Name, err.Error()))
+		}
+	}
+
+	return errs
+}
+
 // Process the raw event
 func (ev *Event) Process(subject string, data []byte) error {
-	ev.action = strings.Split(subject, ".")[1]
+	parts := strings.Split(subject, ".")
+	if len(parts) < 3 {
+		return ErrSubjectInvalid
+	}
+	ev.resource = parts[0]
+	ev.action = parts[1]
+	ev.budget = newRetryBudget()
 
 	err := json.Unmarshal(data, &ev)
 	if err != nil {
-		nc.Publish("route53."+ev.action+".aws.error", data)
+		ev.getPublisher().Publish(ev.resource+"."+ev.action+".aws.error", data)
+		return err
+	}
+
+	// events without a region fall back to the environment's
+	if ev.DatacenterRegion == "" {
+		ev.DatacenterRegion = envRegion()
 	}
-	return err
+
+	return nil
+}
+
+func errorMessage(err error) string {
+	// aws support needs the request id to investigate failed requests
+	if rf, ok := err.(awserr.RequestFailure); ok {
+		return fmt.Sprintf("%s: %s (request id: %s)", rf.Code(), rf.Message(), rf.RequestID())
+	}
+	return err.Error()
+}
+
+// logf logs a message for the event, prefixed by its datacenter so logs from
+// several accounts can be told apart
+func (ev *Event) logf(level int, format string, v ...interface{}) {
+	if ev.DatacenterName != "" {
+		format = "[" + ev.DatacenterName + "] " + format
+	}
+	logf(level, format, v...)
 }
 
 // Error the request
 func (ev *Event) Error(err error) {
-	log.Printf("Error: %s", err.Error())
-	ev.ErrorMessage = err.Error()
+	ev.ErrorMessage = errorMessage(err)
+
+	if rf, ok := err.(awserr.RequestFailure); ok {
+		ev.RequestID = rf.RequestID()
+	}
+
+	ev.logf(levelError, "Error: %s", ev.ErrorMessage)
 
 	data, err := json.Marshal(ev)
 	if err != nil {
 		log.Panic(err)
 	}
-	nc.Publish("route53."+ev.action+".aws.error", data)
+	ev.getPublisher().Publish(ev.resource+"."+ev.action+".aws.error", data)
 }
 
 // Complete the request
@@ -119,5 +1086,6 @@ func (ev *Event) Complete() {
 	if err != nil {
 		ev.Error(err)
 	}
-	nc.Publish("route53."+ev.action+".aws.done", data)
+	ev.logf(levelInfo, "Info: completed %s.%s for zone %s", ev.resource, ev.action, ev.Name)
+	ev.getPublisher().Publish(ev.resource+"."+ev.action+".aws.done", data)
 }
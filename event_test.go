@@ -5,15 +5,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
-	ecc "github.com/ernestio/ernest-config-client"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/nats-io/nats"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -28,6 +31,9 @@ var (
 		DatacenterSecret: "key",
 		DatacenterToken:  "token",
 		Name:             "test",
+		Records: Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+		},
 	}
 )
 
@@ -40,26 +46,41 @@ func waitMsg(ch chan *nats.Msg) (*nats.Msg, error) {
 	return nil, errors.New("timeout")
 }
 
-func testSetup() (chan *nats.Msg, chan *nats.Msg) {
+type testPublisher struct {
+	subscriptions map[string]chan *nats.Msg
+}
+
+func (p *testPublisher) ChanSubscribe(subject string, ch chan *nats.Msg) {
+	p.subscriptions[subject] = ch
+}
+
+func (p *testPublisher) Publish(subject string, data []byte) error {
+	if ch, ok := p.subscriptions[subject]; ok {
+		ch <- &nats.Msg{Subject: subject, Data: data}
+	}
+	return nil
+}
+
+func testSetup() (*testPublisher, chan *nats.Msg, chan *nats.Msg) {
 	doneChan := make(chan *nats.Msg, 10)
 	errChan := make(chan *nats.Msg, 10)
 
-	nc = ecc.NewConfig(os.Getenv("NATS_URI")).Nats()
+	pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
 
-	nc.ChanSubscribe("route53.create.aws.done", doneChan)
-	nc.ChanSubscribe("route53.create.aws.error", errChan)
+	pub.ChanSubscribe("route53.create.aws.done", doneChan)
+	pub.ChanSubscribe("route53.create.aws.error", errChan)
 
-	return doneChan, errChan
+	return pub, doneChan, errChan
 }
 
 func TestEvent(t *testing.T) {
-	completed, errored := testSetup()
+	pub, completed, errored := testSetup()
 
 	Convey("Given I an event", t, func() {
 		Convey("With valid fields", func() {
 			valid, _ := json.Marshal(testEvent)
 			Convey("When processing the event", func() {
-				var e Event
+				e := Event{publisher: pub}
 				err := e.Process("route53.create.aws", valid)
 
 				Convey("It should not error", func() {
@@ -80,8 +101,29 @@ func TestEvent(t *testing.T) {
 				})
 			})
 
+			Convey("When processing an event naming its zone in another form", func() {
+				named := testEvent
+				named.Name = "Test."
+				named.CallerReference = "service-1234"
+				named.Zones = []Zone{{Name: "EU.Test."}}
+				data, _ := json.Marshal(named)
+
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+
+				Convey("It should keep the zone names as given", func() {
+					So(e.Name, ShouldEqual, "Test.")
+					So(e.Zones[0].Name, ShouldEqual, "EU.Test.")
+				})
+
+				Convey("It should build the names of the zone in lower case", func() {
+					So(manifestName(e.Name), ShouldEqual, "_ernest-managed.test")
+					So(e.forZone(&e.Zones[0]).CallerReference, ShouldEqual, "service-1234-eu.test")
+				})
+			})
+
 			Convey("When validating the event", func() {
-				var e Event
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", valid)
 				err := e.Validate()
 
@@ -94,7 +136,7 @@ func TestEvent(t *testing.T) {
 			})
 
 			Convey("When completing the event", func() {
-				var e Event
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", valid)
 				e.Complete()
 				Convey("It should produce aroute53.create.aws.done event", func() {
@@ -110,7 +152,7 @@ func TestEvent(t *testing.T) {
 
 			Convey("When erroring the event", func() {
 				log.SetOutput(ioutil.Discard)
-				var e Event
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", valid)
 				e.Error(errors.New("error"))
 				Convey("It should produce a route53.create.aws.error event", func() {
@@ -124,6 +166,64 @@ func TestEvent(t *testing.T) {
 				})
 				log.SetOutput(os.Stdout)
 			})
+
+			Convey("When erroring the event with an aws request failure", func() {
+				log.SetOutput(ioutil.Discard)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				e.Error(awserr.NewRequestFailure(awserr.New("InvalidChangeBatch", "invalid change", nil), 400, "7a62c49f-347e-4fc4-9331-6e8eEXAMPLE"))
+				Convey("It should include the request id in the error message", func() {
+					msg, timeout := waitMsg(errored)
+					So(msg, ShouldNotBeNil)
+					So(string(msg.Data), ShouldContainSubstring, `"error_message":"InvalidChangeBatch: invalid change (request id: 7a62c49f-347e-4fc4-9331-6e8eEXAMPLE)"`)
+					So(timeout, ShouldBeNil)
+				})
+
+				Convey("It should include the request id on the error event", func() {
+					So(e.RequestID, ShouldEqual, "7a62c49f-347e-4fc4-9331-6e8eEXAMPLE")
+					msg, _ := waitMsg(errored)
+					So(msg, ShouldNotBeNil)
+					So(string(msg.Data), ShouldContainSubstring, `"request_id":"7a62c49f-347e-4fc4-9331-6e8eEXAMPLE"`)
+				})
+				log.SetOutput(os.Stdout)
+			})
+		})
+
+		Convey("With a datacenter name", func() {
+			testEventNamed := testEvent
+			testEventNamed.DatacenterName = "production"
+			data, _ := json.Marshal(testEventNamed)
+
+			Convey("When erroring the event", func() {
+				var logs bytes.Buffer
+				log.SetOutput(&logs)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				e.Error(errors.New("error"))
+				log.SetOutput(os.Stdout)
+
+				Convey("It should include the datacenter name on the error event and log", func() {
+					msg, timeout := waitMsg(errored)
+					So(timeout, ShouldBeNil)
+					So(string(msg.Data), ShouldContainSubstring, `"datacenter_name":"production"`)
+					So(logs.String(), ShouldContainSubstring, "[production] Error: error")
+				})
+			})
+		})
+
+		Convey("With invalid json", func() {
+			Convey("When processing the event", func() {
+				e := Event{publisher: pub}
+				err := e.Process("route53.create.aws", []byte(`{"name":`))
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					msg, timeout := waitMsg(errored)
+					So(msg, ShouldNotBeNil)
+					So(string(msg.Data), ShouldEqual, `{"name":`)
+					So(timeout, ShouldBeNil)
+				})
+			})
 		})
 
 		Convey("With no datacenter access key", func() {
@@ -132,7 +232,7 @@ func TestEvent(t *testing.T) {
 			invalid, _ := json.Marshal(testEventInvalid)
 
 			Convey("When validating the event", func() {
-				var e Event
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", invalid)
 				err := e.Validate()
 				Convey("It should error", func() {
@@ -148,7 +248,7 @@ func TestEvent(t *testing.T) {
 			invalid, _ := json.Marshal(testEventInvalid)
 
 			Convey("When validating the event", func() {
-				var e Event
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", invalid)
 				err := e.Validate()
 				Convey("It should error", func() {
@@ -158,13 +258,95 @@ func TestEvent(t *testing.T) {
 			})
 		})
 
-		Convey("With no vpc id", func() {
+		Convey("With a public zone and no vpc id", func() {
+			testEventValid := testEvent
+			testEventValid.VPCID = ""
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a private zone and a valid vpc id", func() {
+			testEventValid := testEvent
+			testEventValid.Private = true
+			testEventValid.VPCID = "vpc-0a1b2c3d"
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a private zone and no vpc id", func() {
 			testEventInvalid := testEvent
+			testEventInvalid.Private = true
 			testEventInvalid.VPCID = ""
 			invalid, _ := json.Marshal(testEventInvalid)
 
 			Convey("When validating the event", func() {
-				var e Event
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Datacenter VPC ID invalid")
+				})
+			})
+		})
+
+		Convey("With a public zone and a vpc id", func() {
+			testEventValid := testEvent
+			testEventValid.VPCID = "not-a-vpc"
+			testEventValid.HostedZoneID = "/hostedzone/TEST"
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.update.aws", valid)
+				err := e.Validate()
+				Convey("It should ignore the vpc id", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a private zone and no vpc region", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Private = true
+			testEventInvalid.DatacenterRegion = ""
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 private zone VPC region invalid")
+				})
+			})
+		})
+
+		Convey("With a private zone and an invalid vpc id", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Private = true
+			testEventInvalid.VPCID = "subnet-123"
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", invalid)
 				err := e.Validate()
 				Convey("It should error", func() {
@@ -180,7 +362,7 @@ func TestEvent(t *testing.T) {
 			invalid, _ := json.Marshal(testEventInvalid)
 
 			Convey("When validating the event", func() {
-				var e Event
+				e := Event{publisher: pub}
 				e.Process("route53.create.aws", invalid)
 				err := e.Validate()
 				Convey("It should error", func() {
@@ -190,5 +372,1118 @@ func TestEvent(t *testing.T) {
 			})
 		})
 
+		Convey("With valid mx and srv records", func() {
+			testEventValid := testEvent
+			testEventValid.Records = Records{
+				{Entry: "test", Type: "MX", Values: []string{"10 mail.test"}, TTL: 300},
+				{Entry: "_sip._tcp.test", Type: "SRV", Values: []string{"0 65535 5060 sip.test"}, TTL: 300},
+			}
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With an out of range mx priority", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Records = Records{
+				{Entry: "test", Type: "MX", Values: []string{"70000 mail.test"}, TTL: 300},
+			}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record test has an invalid MX priority '70000', must be between 0 and 65535")
+				})
+			})
+		})
+
+		Convey("With an out of range srv port", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Records = Records{
+				{Entry: "_sip._tcp.test", Type: "SRV", Values: []string{"10 5 70000 sip.test"}, TTL: 300},
+			}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record _sip._tcp.test has an invalid SRV port '70000', must be between 0 and 65535")
+				})
+			})
+		})
+
+		Convey("With a get event that only has a hosted zone id", func() {
+			testEventGet := Event{
+				HostedZoneID:     "/hostedzone/TEST",
+				DatacenterRegion: "eu-west-1",
+				DatacenterSecret: "key",
+				DatacenterToken:  "token",
+			}
+			valid, _ := json.Marshal(testEventGet)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.get.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a valid naptr record", func() {
+			testEventValid := testEvent
+			testEventValid.Records = Records{
+				{Entry: "4.3.2.1.5.5.5.0.0.8.1.e164.test", Type: "NAPTR", Values: []string{`100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`}, TTL: 300},
+				{Entry: "test", Type: "NAPTR", Values: []string{`10 0 "s" "SIP+D2U" "" _sip._udp.test`}, TTL: 300},
+			}
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a naptr record with unquoted flags", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Records = Records{
+				{Entry: "test", Type: "NAPTR", Values: []string{`100 10 u "E2U+sip" "!^.*$!sip:info@example.com!" .`}, TTL: 300},
+			}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record test has an invalid NAPTR flags 'u', must be quoted")
+				})
+			})
+		})
+
+		Convey("With a naptr record with both a regexp and replacement", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Records = Records{
+				{Entry: "test", Type: "NAPTR", Values: []string{`100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" sip.test`}, TTL: 300},
+			}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "regexp and replacement can not both be set")
+				})
+			})
+		})
+
+		Convey("With no records", func() {
+			testEventEmpty := testEvent
+			testEventEmpty.Records = nil
+			testEventEmpty.HostedZoneID = "/hostedzone/TEST"
+			empty, _ := json.Marshal(testEventEmpty)
+
+			Convey("When validating a create event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", empty)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 zone records empty")
+				})
+			})
+
+			Convey("When validating a create event that allows an empty zone", func() {
+				testEventEmpty.AllowEmptyZone = true
+				empty, _ := json.Marshal(testEventEmpty)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", empty)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a delete event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.delete.aws", empty)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a caller reference", func() {
+			testEventRef := testEvent
+
+			Convey("When validating a reference within the limit", func() {
+				testEventRef.CallerReference = strings.Repeat("a", 128)
+				data, _ := json.Marshal(testEventRef)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+					So(e.CallerReference, ShouldEqual, testEventRef.CallerReference)
+				})
+			})
+
+			Convey("When validating a reference over the limit", func() {
+				testEventRef.CallerReference = strings.Repeat("a", 129)
+				data, _ := json.Marshal(testEventRef)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 caller reference must be at most 128 characters")
+				})
+			})
+		})
+
+		Convey("With a cname at the zone apex", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Records = Records{
+				{Entry: "test.", Type: "CNAME", Values: []string{"www.test"}, TTL: 300},
+			}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record test. can not be a CNAME at the zone apex, use an alias A record instead")
+				})
+			})
+		})
+
+		Convey("With multiple zones", func() {
+			testEventZones := testEvent
+			testEventZones.Name = ""
+			testEventZones.Records = nil
+			testEventZones.Zones = []Zone{
+				{Name: "test", Records: testEvent.Records},
+				{Name: "eu.test", Records: Records{
+					{Entry: "eu.test", Type: "CNAME", Values: []string{"test"}, TTL: 300},
+				}},
+			}
+			data, _ := json.Marshal(testEventZones)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should validate each zone", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Zone eu.test: Record eu.test can not be a CNAME at the zone apex, use an alias A record instead")
+				})
+			})
+
+			Convey("When several zones have problems", func() {
+				testEventZones.CallerReference = "service-1234"
+				testEventZones.Zones = append(testEventZones.Zones,
+					Zone{Name: "", Records: testEvent.Records},
+					Zone{Name: "us.test", Records: Records{
+						{Entry: "us.test", Type: "CNAME", Values: []string{"test"}, TTL: 300},
+						{Entry: "www.us.test", Type: "A", Values: []string{"not-an-ip"}, TTL: 300},
+					}},
+				)
+				data, _ := json.Marshal(testEventZones)
+
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.ValidateAll()
+
+				Convey("It should report every problem of each zone", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "Zone eu.test: Record eu.test can not be a CNAME")
+					So(err.Error(), ShouldContainSubstring, "Zone : Route53 zone name invalid")
+					So(err.Error(), ShouldContainSubstring, "Zone us.test: Record us.test can not be a CNAME")
+					So(err.Error(), ShouldContainSubstring, "Zone us.test: Record www.us.test")
+				})
+			})
+		})
+
+		Convey("With only part of the read credentials", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.ReadDatacenterSecret = "read-key"
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Datacenter credentials invalid")
+				})
+			})
+		})
+
+		Convey("With a zone name that is too long", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Name = strings.Repeat(strings.Repeat("a", 63)+".", 4) + "test"
+			testEventInvalid.Records = nil
+			testEventInvalid.AllowEmptyZone = true
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 zone name invalid: name exceeds 255 characters")
+				})
+			})
+		})
+
+		Convey("With a zone name with an empty label", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Name = "foo..test"
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 zone name invalid: name contains an empty label")
+				})
+			})
+		})
+
+		Convey("With a zone name with a label that is too long", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Name = strings.Repeat("a", 64) + ".test"
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 zone name invalid: label "+strings.Repeat("a", 64)+" exceeds 63 characters")
+				})
+			})
+		})
+
+		Convey("With a zone name with invalid characters", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Name = "my_zone.test"
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 zone name invalid: label my_zone contains invalid characters")
+				})
+			})
+		})
+
+		Convey("With names containing wildcard labels", func() {
+			Convey("When validating a leftmost wildcard", func() {
+				err := validateDNSName("*.example.com")
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a wildcard that is not leftmost", func() {
+				err := validateDNSName("www.*.example.com")
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "label * contains invalid characters")
+				})
+			})
+		})
+
+		Convey("With a valid fully qualified zone name", func() {
+			testEventValid := testEvent
+			testEventValid.Name = "eu-west-1.example.com."
+			testEventValid.Records = nil
+			testEventValid.AllowEmptyZone = true
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a record to delete without values", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.HostedZoneID = "/hostedzone/TEST"
+			testEventInvalid.RecordsToDelete = Records{
+				{Entry: "old.test", Type: "A"},
+			}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.update.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record old.test to delete requires its values and ttl")
+				})
+			})
+		})
+
+		Convey("With a public zone and no region", func() {
+			testEventValid := testEvent
+			testEventValid.DatacenterRegion = ""
+			valid, _ := json.Marshal(testEventValid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", valid)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With no hosted zone id", func() {
+			testEventNoID := testEvent
+			data, _ := json.Marshal(testEventNoID)
+
+			Convey("When validating an update event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.update.aws", data)
+				err := e.Validate()
+				Convey("It should not error, the zone is resolved by name", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a delete event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.delete.aws", data)
+				err := e.Validate()
+				Convey("It should not error, the zone is resolved by name", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a create event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a private zone and more vpcs than allowed", func() {
+			maxVPCAssociations = 2
+			testEventInvalid := testEvent
+			testEventInvalid.Private = true
+			testEventInvalid.VPCs = []VPC{{VPCID: "vpc-11111111"}, {VPCID: "vpc-22222222"}}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error before making any associations", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 private zone can be associated with at most 2 vpcs, 3 requested")
+				})
+			})
+
+			Reset(func() {
+				maxVPCAssociations = 300
+			})
+		})
+
+		Convey("With a private zone and an invalid additional vpc", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.Private = true
+			testEventInvalid.VPCs = []VPC{{VPCID: "subnet-11111111"}}
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldEqual, ErrDatacenterIDInvalid)
+				})
+			})
+		})
+
+		Convey("With a vpc of another account", func() {
+			testEventCross := testEvent
+			testEventCross.VPCs = []VPC{{VPCID: "vpc-22222222", DatacenterSecret: "other", DatacenterToken: "token"}}
+
+			Convey("When validating a private zone", func() {
+				testEventCross.Private = true
+				data, _ := json.Marshal(testEventCross)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a public zone", func() {
+				data, _ := json.Marshal(testEventCross)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 vpc vpc-22222222 of another account can only be associated with a private zone")
+				})
+			})
+
+			Convey("When validating a private zone without the vpc account's token", func() {
+				testEventCross.Private = true
+				testEventCross.VPCs[0].DatacenterToken = ""
+				data, _ := json.Marshal(testEventCross)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldEqual, ErrDatacenterCredentialsInvalid)
+				})
+			})
+
+			Convey("When validating a private zone created with it", func() {
+				testEventCross.Private = true
+				testEventCross.VPCID = ""
+				data, _ := json.Marshal(testEventCross)
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 private zone must be created with a vpc of its own account, vpc-22222222 is of another account")
+				})
+			})
+		})
+
+		Convey("With a maximum number of records per event configured", func() {
+			maxEventRecords = 3
+			Reset(func() {
+				maxEventRecords = 10000
+			})
+
+			Convey("When validating an event over the limit across its zones", func() {
+				testEventRecords := testEvent
+				testEventRecords.Zones = []Zone{
+					{Name: "one.test", Records: Records{
+						{Entry: "a.one.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+						{Entry: "b.one.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+					}},
+					{Name: "two.test", Records: Records{
+						{Entry: "a.two.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+						{Entry: "b.two.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+					}},
+				}
+				data, _ := json.Marshal(testEventRecords)
+
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 event can have at most 3 records, 5 given")
+				})
+			})
+		})
+
+		Convey("With a maximum number of records configured", func() {
+			maxRecords = 2
+			Reset(func() {
+				maxRecords = 10000
+			})
+
+			Convey("When validating an event just under the limit", func() {
+				testEventRecords := testEvent
+				testEventRecords.Records = Records{
+					{Entry: "a.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+					{Entry: "b.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+				}
+				data, _ := json.Marshal(testEventRecords)
+
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating an event just over the limit", func() {
+				testEventRecords := testEvent
+				testEventRecords.Records = Records{
+					{Entry: "a.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+					{Entry: "b.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+					{Entry: "c.test", Type: "A", Values: []string{"10.0.0.3"}, TTL: 300},
+				}
+				data, _ := json.Marshal(testEventRecords)
+
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 zone can hold at most 2 records, 3 requested")
+				})
+			})
+		})
+
+		Convey("With an allow-list of zones", func() {
+			allowedZones = parseAllowedZones(" .Example.com, internal.,")
+			Reset(func() {
+				allowedZones = nil
+			})
+
+			Convey("When validating an allowed zone", func() {
+				e := testEvent
+				e.Name = "example.com"
+				e.Records = Records{{Entry: "www.example.com", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300}}
+				err := e.Validate()
+
+				Convey("It should not error", func() {
+					So(allowedZones, ShouldResemble, []string{".example.com", ".internal"})
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a zone outside of it", func() {
+				e := testEvent
+				e.Name = "notexample.com"
+				e.Records = Records{{Entry: "www.notexample.com", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300}}
+				err := e.ValidateAll()
+
+				Convey("It should reject the zone and its records", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 event has 2 validation errors: Route53 zone notexample.com is not in the zones the connector may manage; Record www.notexample.com is not in the zones the connector may manage")
+				})
+			})
+		})
+
+		Convey("Without an allow-list of zones", func() {
+			Convey("When validating any zone", func() {
+				e := testEvent
+				e.Name = "notexample.com"
+				e.Records = Records{{Entry: "www.notexample.com", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300}}
+				err := e.Validate()
+
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With records specifying routing policies", func() {
+			weight := int64(10)
+			geo := &GeoLocation{CountryCode: "GB"}
+
+			invalid := []struct {
+				record   Record
+				expected string
+			}{
+				{Record{Weight: &weight, Failover: "PRIMARY"}, "weight and failover"},
+				{Record{Weight: &weight, GeoLocation: geo}, "weight and geo_location"},
+				{Record{Weight: &weight, Region: "eu-west-1"}, "weight and region"},
+				{Record{Region: "eu-west-1", Failover: "PRIMARY"}, "region and failover"},
+				{Record{Region: "eu-west-1", GeoLocation: geo}, "region and geo_location"},
+				{Record{Failover: "PRIMARY", GeoLocation: geo}, "failover and geo_location"},
+				{Record{Weight: &weight, Failover: "PRIMARY", GeoLocation: geo}, "weight and failover and geo_location"},
+			}
+
+			for _, test := range invalid {
+				Convey("When validating a record with "+test.expected, func() {
+					record := test.record
+					record.Entry = "www.test"
+					record.Type = "A"
+					record.Values = []string{"10.0.0.1"}
+					record.SetIdentifier = "one"
+					err := record.Validate("test")
+
+					Convey("It should name the conflicting policies", func() {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, "Record www.test can only have one routing policy, got "+test.expected)
+					})
+				})
+			}
+
+			valid := map[string]Record{
+				"weight":        {Weight: &weight},
+				"region":        {Region: "eu-west-1"},
+				"failover":      {Failover: "PRIMARY"},
+				"geo_location":  {GeoLocation: geo},
+				"geo_proximity": {GeoProximity: &GeoProximity{AWSRegion: "eu-west-1"}},
+			}
+
+			for policy, record := range valid {
+				Convey("When validating a record with only "+policy, func() {
+					record.Entry = "www.test"
+					record.Type = "A"
+					record.Values = []string{"10.0.0.1"}
+					record.SetIdentifier = "one"
+					err := record.Validate("test")
+
+					Convey("It should not error", func() {
+						So(err, ShouldBeNil)
+					})
+				})
+			}
+		})
+
+		Convey("With a weighted record without a set identifier", func() {
+			weight := int64(10)
+			record := Record{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, Weight: &weight}
+
+			Convey("When validating the record", func() {
+				err := record.Validate("test")
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test has a weight routing policy and requires a set identifier")
+				})
+			})
+		})
+
+		Convey("With records specifying an invalid geoproximity", func() {
+			invalid := []struct {
+				name      string
+				proximity GeoProximity
+				expected  string
+			}{
+				{"a bias out of range", GeoProximity{AWSRegion: "eu-west-1", Bias: 100}, "Record www.test has an invalid geo_proximity bias 100, must be between -99 and 99"},
+				{"no location", GeoProximity{Bias: 10}, "Record www.test geo_proximity requires either an aws_region or coordinates"},
+				{"a region and coordinates", GeoProximity{AWSRegion: "eu-west-1", Latitude: "51.50", Longitude: "-0.12"}, "Record www.test geo_proximity requires either an aws_region or coordinates"},
+				{"an invalid latitude", GeoProximity{Latitude: "91", Longitude: "-0.12"}, "Record www.test has an invalid geo_proximity latitude '91', must be between -90 and 90"},
+				{"a missing longitude", GeoProximity{Latitude: "51.50"}, "Record www.test has an invalid geo_proximity longitude '', must be between -180 and 180"},
+			}
+
+			for _, test := range invalid {
+				Convey("When validating a record with "+test.name, func() {
+					proximity := test.proximity
+					record := Record{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, SetIdentifier: "one", GeoProximity: &proximity}
+					err := record.Validate("test")
+
+					Convey("It should error", func() {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldEqual, test.expected)
+					})
+				})
+			}
+
+			Convey("When validating a record with coordinates", func() {
+				record := Record{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, SetIdentifier: "one", GeoProximity: &GeoProximity{Latitude: "51.50", Longitude: "-0.12", Bias: -99}}
+				err := record.Validate("test")
+
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a record referencing an invalid health check id", func() {
+			record := Record{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, HealthCheckID: "not-a-health-check"}
+
+			Convey("When validating the record", func() {
+				err := record.Validate("test")
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test has an invalid health check id 'not-a-health-check'")
+				})
+			})
+		})
+
+		Convey("With a record entry with a label over 63 characters", func() {
+			record := Record{Entry: strings.Repeat("a", 64) + ".test", Type: "A", Values: []string{"10.0.0.1"}}
+
+			Convey("When validating the record", func() {
+				err := record.Validate("test")
+				Convey("It should report the entry", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record "+record.Entry+" has an invalid entry: label "+strings.Repeat("a", 64)+" exceeds 63 characters")
+				})
+			})
+		})
+
+		Convey("With a record entry with an illegal character", func() {
+			record := Record{Entry: "my host.test", Type: "A", Values: []string{"10.0.0.1"}}
+
+			Convey("When validating the record", func() {
+				err := record.Validate("test")
+				Convey("It should report the entry", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record my host.test has an invalid entry: label my host contains invalid characters")
+				})
+			})
+		})
+
+		Convey("With a record without an entry", func() {
+			allowedZones = parseAllowedZones(".test")
+			Reset(func() {
+				allowedZones = nil
+			})
+
+			e := testEvent
+			e.Records = Records{{Entry: "", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300}}
+
+			Convey("When validating the event", func() {
+				err := e.ValidateAll()
+				Convey("It should error without panicking", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "Record entry can not be empty")
+					So(entryName(""), ShouldEqual, "")
+				})
+			})
+		})
+
+		Convey("With a record entry with an underscore inside a label", func() {
+			record := Record{Entry: "my_host.test", Type: "A", Values: []string{"10.0.0.1"}}
+
+			Convey("When validating the record", func() {
+				err := record.Validate("test")
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("With an srv record with underscore prefixed labels", func() {
+			record := Record{Entry: "_sip._tcp.test", Type: "SRV", TTL: 300, Values: []string{"10 60 5060 sip.test"}}
+
+			Convey("When validating the record", func() {
+				err := record.Validate("test")
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With a targeted update of a record without a set identifier", func() {
+			testEventTargeted := testEvent
+			testEventTargeted.HostedZoneID = "/hostedzone/TEST"
+			testEventTargeted.Targeted = true
+			data, _ := json.Marshal(testEventTargeted)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.update.aws", data)
+				err := e.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test requires a set identifier to be updated on its own")
+				})
+			})
+		})
+
+		Convey("With address records", func() {
+			tests := []struct {
+				record   Record
+				expected string
+			}{
+				{Record{Entry: "v4.test", Type: "A", Values: []string{"10.0.0.1"}}, ""},
+				{Record{Entry: "v6.test", Type: "AAAA", Values: []string{"2001:db8::1"}}, ""},
+				{Record{Entry: "v4.test", Type: "A", Values: []string{"10.0.0.256"}}, "Record v4.test has an invalid A value '10.0.0.256', must be an ipv4 address"},
+				{Record{Entry: "v4.test", Type: "A", Values: []string{"2001:db8::1"}}, "Record v4.test has an invalid A value '2001:db8::1', must be an ipv4 address"},
+				{Record{Entry: "v6.test", Type: "AAAA", Values: []string{"2001:db8::1::2"}}, "Record v6.test has an invalid AAAA value '2001:db8::1::2', must be an ipv6 address"},
+				{Record{Entry: "v6.test", Type: "AAAA", Values: []string{"10.0.0.1"}}, "Record v6.test has an invalid AAAA value '10.0.0.1', must be an ipv6 address"},
+				{Record{Entry: "v6.test", Type: "AAAA", Values: []string{"::ffff:192.0.2.1"}}, "Record v6.test has an invalid AAAA value '::ffff:192.0.2.1', must not be an ipv4 mapped address"},
+			}
+
+			for _, test := range tests {
+				Convey("When validating the "+test.record.Type+" value "+test.record.Values[0], func() {
+					err := test.record.Validate("test")
+
+					if test.expected == "" {
+						Convey("It should not error", func() {
+							So(err, ShouldBeNil)
+						})
+					} else {
+						Convey("It should report the entry and value", func() {
+							So(err, ShouldNotBeNil)
+							So(err.Error(), ShouldEqual, test.expected)
+						})
+					}
+				})
+			}
+		})
+
+		Convey("With records of several ttls", func() {
+			tests := []struct {
+				ttl      int64
+				expected string
+			}{
+				{0, ""},
+				{1, ""},
+				{604800, ""},
+				{-1, "Record www.test has an invalid ttl -1, must be between 1 and 604800"},
+				{604801, "Record www.test has an invalid ttl 604801, must be between 1 and 604800"},
+			}
+
+			for _, test := range tests {
+				Convey(fmt.Sprintf("When validating a ttl of %d", test.ttl), func() {
+					record := Record{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: test.ttl}
+					err := record.Validate("test")
+
+					if test.expected == "" {
+						Convey("It should not error", func() {
+							So(err, ShouldBeNil)
+						})
+					} else {
+						Convey("It should report the entry and ttl", func() {
+							So(err, ShouldNotBeNil)
+							So(err.Error(), ShouldEqual, test.expected)
+						})
+					}
+				})
+			}
+
+			Convey("When validating a ttl below a configured minimum", func() {
+				minRecordTTL = 60
+				Reset(func() {
+					minRecordTTL = 1
+				})
+
+				record := Record{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 59}
+				err := record.Validate("test")
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test has an invalid ttl 59, must be between 60 and 604800")
+				})
+			})
+		})
+
+		Convey("With alias records", func() {
+			weight := int64(10)
+			alias := &Alias{HostedZoneID: "Z32O12XQLNTSW2", DNSName: "web-123.eu-west-1.elb.amazonaws.com"}
+
+			Convey("When validating a weighted alias", func() {
+				record := Record{Entry: "www.test", Type: "A", Alias: alias, SetIdentifier: "blue", Weight: &weight}
+				err := record.Validate("test")
+
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a geolocation alias", func() {
+				record := Record{Entry: "www.test", Type: "A", Alias: alias, SetIdentifier: "eu", GeoLocation: &GeoLocation{ContinentCode: "EU"}}
+				err := record.Validate("test")
+
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When validating a weighted alias with a ttl", func() {
+				record := Record{Entry: "www.test", Type: "A", Alias: alias, SetIdentifier: "blue", Weight: &weight, TTL: 300}
+				err := record.Validate("test")
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test is an alias and can not have a ttl or values")
+				})
+			})
+
+			for _, evaluate := range []bool{true, false} {
+				Convey(fmt.Sprintf("When validating an alias evaluating target health %t", evaluate), func() {
+					record := Record{Entry: "www.test", Type: "A", Alias: &Alias{HostedZoneID: alias.HostedZoneID, DNSName: alias.DNSName, EvaluateTargetHealth: evaluate}}
+					err := record.Validate("test")
+
+					Convey("It should not error", func() {
+						So(err, ShouldBeNil)
+					})
+				})
+			}
+
+			Convey("When validating a record evaluating target health without an alias", func() {
+				var record Record
+				json.Unmarshal([]byte(`{"entry":"www.test","type":"A","values":["10.0.0.1"],"ttl":300,"evaluate_target_health":false}`), &record)
+				err := record.Validate("test")
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test can only evaluate target health as an alias, set evaluate_target_health on its alias")
+				})
+			})
+
+			Convey("When validating an alias with values", func() {
+				record := Record{Entry: "www.test", Type: "A", Alias: alias, Values: []string{"10.0.0.1"}}
+				err := record.Validate("test")
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test is an alias and can not have a ttl or values")
+				})
+			})
+
+			Convey("When validating a weighted alias with another routing policy", func() {
+				record := Record{Entry: "www.test", Type: "A", Alias: alias, SetIdentifier: "blue", Weight: &weight, Failover: "PRIMARY"}
+				err := record.Validate("test")
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Record www.test can only have one routing policy, got weight and failover")
+				})
+			})
+		})
+
+		Convey("With an SOA override", func() {
+			Convey("When the retry is not less than the refresh", func() {
+				soa := SOA{Refresh: 600, Retry: 600, Expire: 604800, MinimumTTL: 300}
+				err := soa.Validate()
+				Convey("It should leave the timers to the caller", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+
+			Convey("When a timer is missing", func() {
+				soa := SOA{Refresh: 3600, Retry: 600, Expire: 604800}
+				err := soa.Validate()
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 SOA minimum_ttl 0 must be between 1 and 2147483647")
+				})
+			})
+
+			Convey("When the timers are valid", func() {
+				soa := SOA{Refresh: 3600, Retry: 600, Expire: 604800, MinimumTTL: 300}
+				err := soa.Validate()
+				Convey("It should not error", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("With several problems at once", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.DatacenterToken = ""
+			testEventInvalid.DatacenterRegion = ""
+			testEventInvalid.Name = "my_zone.test"
+			testEventInvalid.Private = true
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating all criteria", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.ValidateAll()
+
+				Convey("It should report every problem", func() {
+					So(err, ShouldNotBeNil)
+					errs, ok := err.(ErrorList)
+					So(ok, ShouldBeTrue)
+					So(len(errs), ShouldEqual, 3)
+					So(errs[0], ShouldEqual, ErrVPCRegionInvalid)
+					So(errs[1], ShouldEqual, ErrDatacenterCredentialsInvalid)
+					So(err.Error(), ShouldEqual, "Route53 event has 3 validation errors: Route53 private zone VPC region invalid; Datacenter credentials invalid; Route53 zone name invalid: label my_zone contains invalid characters")
+				})
+			})
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", invalid)
+				err := e.Validate()
+
+				Convey("It should only report the first problem", func() {
+					So(err, ShouldEqual, ErrVPCRegionInvalid)
+				})
+			})
+		})
+
+		Convey("With an upsert without a hosted zone id", func() {
+			testEventInvalid := testEvent
+			testEventInvalid.HostedZoneID = ""
+			invalid, _ := json.Marshal(testEventInvalid)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.upsert.aws", invalid)
+				err := e.Validate()
+
+				Convey("It should error", func() {
+					So(err, ShouldEqual, ErrHostedZoneIDRequired)
+				})
+			})
+		})
+
+		Convey("With no region and a region set in the environment", func() {
+			os.Setenv("AWS_REGION", "eu-west-2")
+			Reset(func() {
+				os.Unsetenv("AWS_REGION")
+			})
+
+			testEventNoRegion := testEvent
+			testEventNoRegion.DatacenterRegion = ""
+			testEventNoRegion.Private = true
+			data, _ := json.Marshal(testEventNoRegion)
+
+			Convey("When validating the event", func() {
+				e := Event{publisher: pub}
+				e.Process("route53.create.aws", data)
+				err := e.Validate()
+
+				Convey("It should use the environment's region", func() {
+					So(err, ShouldBeNil)
+					So(e.DatacenterRegion, ShouldEqual, "eu-west-2")
+					So(e.vpcs()[0].VPCRegion, ShouldEqual, "eu-west-2")
+				})
+			})
+		})
+
+		Convey("With a malformed subject", func() {
+			valid, _ := json.Marshal(testEvent)
+
+			Convey("When processing the event", func() {
+				e := Event{publisher: pub}
+				err := e.Process("route53", valid)
+
+				Convey("It should error without publishing", func() {
+					So(err, ShouldEqual, ErrSubjectInvalid)
+					msg, timeout := waitMsg(errored)
+					So(msg, ShouldBeNil)
+					So(timeout, ShouldNotBeNil)
+				})
+			})
+		})
 	})
 }
@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// exportRoute53 reads an existing zone into the shape of a create event, so
+// it can be replayed to recreate the zone
+func exportRoute53(ev *Event) error {
+	if err := resolveZoneID(ev); err != nil {
+		return err
+	}
+
+	svc := getRoute53ReadClient(ev)
+
+	resp, err := svc.GetHostedZone(&route53.GetHostedZoneInput{
+		Id: aws.String(ev.HostedZoneID),
+	})
+	if err != nil {
+		return err
+	}
+
+	ev.Name = entryName(*resp.HostedZone.Name)
+	ev.Private = resp.HostedZone.Config != nil && aws.BoolValue(resp.HostedZone.Config.PrivateZone)
+	ev.VPCs = nil
+
+	for i, vpc := range resp.VPCs {
+		if i == 0 {
+			ev.VPCID = aws.StringValue(vpc.VPCId)
+			ev.VPCRegion = aws.StringValue(vpc.VPCRegion)
+			continue
+		}
+		ev.VPCs = append(ev.VPCs, VPC{
+			VPCID:     aws.StringValue(vpc.VPCId),
+			VPCRegion: aws.StringValue(vpc.VPCRegion),
+		})
+	}
+
+	zr, err := getZoneRecords(ev)
+	if err != nil {
+		return err
+	}
+
+	var exported []*route53.ResourceRecordSet
+
+	// the default records and manifest are recreated along with the zone
+	for _, recordSet := range zr {
+		if isDefaultRule(ev.Name, recordSet) || isManifest(ev, recordSet) {
+			continue
+		}
+		exported = append(exported, recordSet)
+	}
+
+	ev.Records = recordsFromResourceRecordSets(exported)
+	ev.AllowEmptyZone = len(ev.Records) == 0
+
+	return nil
+}
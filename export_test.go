@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportRoute53(t *testing.T) {
+	Convey("Given a zone with several record types", t, func() {
+		weight := int64(10)
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+			records: []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.test.")}}},
+				{Name: aws.String("test."), Type: aws.String("SOA"), TTL: aws.Int64(900), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.test. hostmaster.test. 1 7200 900 1209600 86400")}}},
+				{Name: aws.String("test."), Type: aws.String("MX"), TTL: aws.Int64(3600), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10 mail.test")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), SetIdentifier: aws.String("blue"), Weight: &weight, ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("txt.test."), Type: aws.String("TXT"), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"v=spf1 -all"`)}}},
+				{Name: aws.String("cdn.test."), Type: aws.String("CNAME"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("cdn.example.com")}}},
+				{Name: aws.String("_ernest-managed.test."), Type: aws.String("TXT"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"www.test A"`)}}},
+			},
+			pageSize: 2,
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When exporting the zone by its id", func() {
+			e := testEvent
+			e.Name = ""
+			e.Records = nil
+			e.HostedZoneID = "/hostedzone/TEST"
+			err := exportRoute53(&e)
+
+			Convey("It should read the zone details", func() {
+				So(err, ShouldBeNil)
+				So(e.Name, ShouldEqual, "test")
+				So(e.Private, ShouldBeFalse)
+			})
+
+			Convey("It should export every page of records except the defaults and manifest", func() {
+				So(e.Records, ShouldResemble, Records{
+					{Entry: "test", Type: "MX", Values: []string{"10 mail.test"}, TTL: 3600},
+					{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300, SetIdentifier: "blue", Weight: &weight},
+					{Entry: "txt.test", Type: "TXT", Values: []string{"v=spf1 -all"}, TTL: 60},
+					{Entry: "cdn.test", Type: "CNAME", Values: []string{"cdn.example.com"}, TTL: 300},
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,55 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// ZoneSummary stores the details of a hosted zone found listing an account
+type ZoneSummary struct {
+	HostedZoneID   string `json:"hosted_zone_id"`
+	Name           string `json:"name"`
+	Private        bool   `json:"private"`
+	RecordSetCount int64  `json:"record_set_count"`
+}
+
+// listZones reads a summary of every allowed hosted zone of the account,
+// without reading or changing any of the zones
+func listZones(ev *Event) error {
+	svc := getRoute53ReadClient(ev)
+
+	req := &route53.ListHostedZonesInput{}
+
+	ev.ListedZones = nil
+
+	for {
+		resp, err := svc.ListHostedZones(req)
+		if err != nil {
+			return err
+		}
+
+		for _, zone := range resp.HostedZones {
+			// zones outside the allow-list are not the connector's to report
+			if !isAllowedName(aws.StringValue(zone.Name)) {
+				continue
+			}
+
+			ev.ListedZones = append(ev.ListedZones, ZoneSummary{
+				HostedZoneID:   aws.StringValue(zone.Id),
+				Name:           entryName(aws.StringValue(zone.Name)),
+				Private:        zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone),
+				RecordSetCount: aws.Int64Value(zone.ResourceRecordSetCount),
+			})
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return nil
+		}
+
+		req.Marker = resp.NextMarker
+	}
+}
@@ -0,0 +1,82 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListZones(t *testing.T) {
+	Convey("Given an account with several zones", t, func() {
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/ONE"), Name: aws.String("one.test."), ResourceRecordSetCount: aws.Int64(2), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+				{Id: aws.String("/hostedzone/TWO"), Name: aws.String("two.test."), ResourceRecordSetCount: aws.Int64(5), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+				{Id: aws.String("/hostedzone/THREE"), Name: aws.String("three.test."), ResourceRecordSetCount: aws.Int64(3)},
+			},
+			pageSize: 2,
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		e := Event{
+			DatacenterRegion: "eu-west-1",
+			DatacenterSecret: "key",
+			DatacenterToken:  "token",
+		}
+		data, _ := json.Marshal(e)
+
+		Convey("When validating a list event without a zone", func() {
+			ev := Event{publisher: &testPublisher{}}
+			ev.Process("route53.list.aws", data)
+			err := ev.Validate()
+
+			Convey("It should not error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When listing the zones across pages", func() {
+			ev := Event{}
+			ev.Process("route53.list.aws", data)
+			handler, _ := eventAction(&ev)
+			err := handler(&ev)
+
+			Convey("It should summarize every zone", func() {
+				So(err, ShouldBeNil)
+				So(ev.ListedZones, ShouldResemble, []ZoneSummary{
+					{HostedZoneID: "/hostedzone/ONE", Name: "one.test", Private: false, RecordSetCount: 2},
+					{HostedZoneID: "/hostedzone/TWO", Name: "two.test", Private: true, RecordSetCount: 5},
+					{HostedZoneID: "/hostedzone/THREE", Name: "three.test", Private: false, RecordSetCount: 3},
+				})
+			})
+		})
+
+		Convey("When listing the zones with an allow-list", func() {
+			allowedZones = parseAllowedZones("two.test")
+			Reset(func() {
+				allowedZones = nil
+			})
+
+			ev := Event{}
+			ev.Process("route53.list.aws", data)
+			err := listZones(&ev)
+
+			Convey("It should only summarize the allowed zones", func() {
+				So(err, ShouldBeNil)
+				So(ev.ListedZones, ShouldResemble, []ZoneSummary{
+					{HostedZoneID: "/hostedzone/TWO", Name: "two.test", Private: true, RecordSetCount: 5},
+				})
+			})
+		})
+	})
+}
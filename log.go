@@ -0,0 +1,51 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// log levels, from the least to the most verbose
+const (
+	levelError = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var levelNames = map[string]int{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// logLevel is the most verbose level that is logged
+var logLevel = levelInfo
+
+// logf logs a message when the level is enabled
+func logf(level int, format string, v ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func getLogLevel() int {
+	if os.Getenv("LOG_LEVEL") == "" {
+		return levelInfo
+	}
+
+	level, ok := levelNames[strings.ToLower(os.Getenv("LOG_LEVEL"))]
+	if !ok {
+		log.Printf("Error: invalid LOG_LEVEL %s, using info", os.Getenv("LOG_LEVEL"))
+		return levelInfo
+	}
+
+	return level
+}
@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogLevel(t *testing.T) {
+	Convey("Given the log level is error", t, func() {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		logLevel = levelError
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			logLevel = levelInfo
+		})
+
+		Convey("When logging an info message", func() {
+			logf(levelInfo, "Info: received %s", "route53.create.aws")
+
+			Convey("It should be suppressed", func() {
+				So(logs.String(), ShouldEqual, "")
+			})
+		})
+
+		Convey("When logging an error", func() {
+			logf(levelError, "Error: %s", "failed")
+
+			Convey("It should be logged", func() {
+				So(logs.String(), ShouldContainSubstring, "Error: failed")
+			})
+		})
+	})
+
+	Convey("Given a log level from the environment", t, func() {
+		Convey("When it is a known level", func() {
+			os.Setenv("LOG_LEVEL", "DEBUG")
+			level := getLogLevel()
+			os.Unsetenv("LOG_LEVEL")
+
+			Convey("It should use the level", func() {
+				So(level, ShouldEqual, levelDebug)
+			})
+		})
+
+		Convey("When it is unset", func() {
+			level := getLogLevel()
+
+			Convey("It should default to info", func() {
+				So(level, ShouldEqual, levelInfo)
+			})
+		})
+	})
+}
@@ -6,15 +6,25 @@ package main
 
 import (
 	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
-	ecc "github.com/ernestio/ernest-config-client"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/nats-io/nats"
 	uuid "github.com/satori/go.uuid"
 )
@@ -22,189 +32,1797 @@ import (
 var nc *nats.Conn
 var natsErr error
 
+// defaultTTL is applied to records without a ttl when the event has no default_ttl
+const defaultTTL = 300
+
+// maxBatchChanges is the most changes route53 accepts in a single request
+var maxBatchChanges = 1000
+
+// maxBatchValueSize is the most value characters route53 accepts in a single request
+var maxBatchValueSize = 32000
+
+// minPollInterval and maxPollInterval bound the wait between change status polls
+var minPollInterval = time.Second
+var maxPollInterval = 30 * time.Second
+
+// syncTimeout is the longest to wait for a change to reach INSYNC
+var syncTimeout = 5 * time.Minute
+
+var sleep = time.Sleep
+var now = time.Now
+
+// defaultRegion is used for route53 calls on events without a region
+var defaultRegion = "us-east-1"
+
+// maxTTL caps the ttl of any record sent to route53, disabled when zero
+var maxTTL int64
+
+// maxRetries is the number of times the sdk retries a failed aws call
+var maxRetries = 3
+
+// maxConcurrentRetries is how many times the changes of an update are built
+// again when the zone is modified by someone else while they are applied
+var maxConcurrentRetries = 3
+
+// httpTimeout bounds each aws request, so a stuck connection can not hang an event
+var httpTimeout = 30 * time.Second
+
 func eventHandler(m *nats.Msg) {
-	var e Event
+	e := Event{publisher: nc}
+
+	err := e.Process(m.Subject, m.Data)
+	if err != nil {
+		logf(levelError, "Error: invalid event on %s: %s", m.Subject, err.Error())
+		return
+	}
+
+	e.logf(levelInfo, "Info: received %s for zone %s", m.Subject, e.Name)
+
+	// validation only events report every problem, without calling aws
+	if e.action == "validate" {
+		if err = validateRoute53(&e); err != nil {
+			e.Error(err)
+			return
+		}
+		e.Complete()
+		return
+	}
+
+	handler, err := eventAction(&e)
+	if err != nil {
+		e.Error(err)
+		return
+	}
+
+	if err = e.ValidateAll(); err != nil {
+		e.Error(err)
+		return
+	}
+
+	if e.VerifyCredentials && e.resource == "route53" {
+		if err = verifyCredentials(&e); err != nil {
+			e.Error(err)
+			return
+		}
+	}
+
+	// held updates are completed once their zone's window closes
+	if coalesces(&e) {
+		coalescer.add(&e)
+		return
+	}
+
+	// updates held for the zone were received first, so are applied first
+	if coalesceWindow > 0 && mutates(&e) {
+		coalescer.flushBefore(&e)
+	}
+
+	err = runEvent(&e, handler)
+	if err != nil {
+		e.Error(budgetError(&e, err))
+		return
+	}
+
+	e.Complete()
+}
+
+// eventAction returns the handler for the event's resource and action
+func eventAction(ev *Event) (func(*Event) error, error) {
+	switch ev.resource + "." + ev.action {
+	case "route53.create":
+		return createRoute53, nil
+	case "route53.update":
+		return updateRoute53, nil
+	case "route53.upsert":
+		return upsertRoute53, nil
+	case "route53.delete":
+		return deleteRoute53, nil
+	case "route53.get":
+		return getZoneStatus, nil
+	case "route53.export":
+		return exportRoute53, nil
+	case "route53.list":
+		return listZones, nil
+	case "route53.diff":
+		return diffRoute53, nil
+	case "route53.import":
+		return importRoute53, nil
+	case "route53_resolver.create":
+		return createResolverRuleAssociation, nil
+	case "route53_resolver.delete":
+		return deleteResolverRuleAssociation, nil
+	default:
+		return nil, fmt.Errorf("Route53 action %s is not supported for %s", ev.action, ev.resource)
+	}
+}
+
+// validateRoute53 collects every validation problem of the event
+func validateRoute53(ev *Event) error {
+	err := ev.ValidateAll()
+
+	ev.ValidationErrors = nil
+	if errs, ok := err.(ErrorList); ok {
+		ev.ValidationErrors = errs.messages()
+	}
+
+	return err
+}
+
+// runEvent applies the event with its handler, measuring how long it took
+func runEvent(ev *Event, handler func(*Event) error) error {
+	ev.Result = Result{}
+
+	start := now()
+	defer func() {
+		ev.DurationMs = int64(now().Sub(start) / time.Millisecond)
+	}()
+
+	if len(ev.Zones) > 0 {
+		return applyZones(ev, handler)
+	}
+
+	return handler(ev)
+}
+
+// applyZones runs the handler against each zone of the event, carrying on
+// with the remaining zones when one fails
+func applyZones(ev *Event, handler func(*Event) error) error {
+	var failed []string
+
+	for i := range ev.Zones {
+		z := &ev.Zones[i]
+		zev := ev.forZone(z)
+
+		err := handler(&zev)
+
+		z.HostedZoneID = zev.HostedZoneID
+		z.Records = zev.Records
+		z.SkippedRecords = zev.SkippedRecords
+		z.RecordSetCount = zev.RecordSetCount
+		z.ResourceRecordCount = zev.ResourceRecordCount
+		z.ErrorMessage = ""
+		ev.ChangeCount += zev.ChangeCount
+		ev.ChangeIDs = append(ev.ChangeIDs, zev.ChangeIDs...)
+
+		if err != nil {
+			z.ErrorMessage = errorMessage(err)
+			failed = append(failed, z.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Route53 zones failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func getZoneRecords(ev *Event) ([]*route53.ResourceRecordSet, error) {
+	svc := getRoute53ReadClient(ev)
+
+	req := &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(ev.HostedZoneID),
+	}
+
+	var recordSets []*route53.ResourceRecordSet
+
+	for {
+		resp, err := svc.ListResourceRecordSets(req)
+		if err != nil {
+			return nil, err
+		}
+
+		recordSets = append(recordSets, resp.ResourceRecordSets...)
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return recordSets, nil
+		}
+
+		req.StartRecordName = resp.NextRecordName
+		req.StartRecordType = resp.NextRecordType
+		req.StartRecordIdentifier = resp.NextRecordIdentifier
+	}
+}
+
+func getZoneID(ev *Event) (string, error) {
+	svc := getRoute53ReadClient(ev)
+
+	req := &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(ev.Name),
+	}
+
+	resp, err := svc.ListHostedZonesByName(req)
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+
+	for _, zone := range resp.HostedZones {
+		if !sameName(*zone.Name, ev.Name) {
+			continue
+		}
+
+		if zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone) == ev.Private {
+			ids = append(ids, *zone.Id)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", ErrHostedZoneNotFound
+	case 1:
+		return ids[0], nil
+	}
+
+	// private zones can share a name across vpcs
+	return "", fmt.Errorf("Route53 zone %s matches multiple hosted zones, a hosted zone id is required: %s", ev.Name, strings.Join(ids, ", "))
+}
+
+// resolveZoneID looks up the hosted zone id by name when the event has none
+func resolveZoneID(ev *Event) error {
+	if ev.HostedZoneID != "" {
+		return checkAllowedZone(ev)
+	}
+
+	id, err := getZoneID(ev)
+	if err != nil {
+		return err
+	}
+
+	ev.HostedZoneID = id
+
+	return nil
+}
+
+// checkAllowedZone refuses a hosted zone id that belongs to a zone outside
+// the allowed zones, or to a zone other than the one the event names
+func checkAllowedZone(ev *Event) error {
+	if len(allowedZones) == 0 {
+		return nil
+	}
+
+	svc := getRoute53ReadClient(ev)
+
+	resp, err := svc.GetHostedZone(&route53.GetHostedZoneInput{
+		Id: aws.String(ev.HostedZoneID),
+	})
+	if err != nil {
+		return err
+	}
+
+	name := entryName(aws.StringValue(resp.HostedZone.Name))
+
+	if !isAllowedName(name) {
+		return fmt.Errorf("Route53 hosted zone %s is zone %s, which is not in the zones the connector may manage", ev.HostedZoneID, name)
+	}
+
+	if ev.Name != "" && !sameName(name, ev.Name) {
+		return fmt.Errorf("Route53 hosted zone %s is zone %s, not %s", ev.HostedZoneID, name, ev.Name)
+	}
+
+	return nil
+}
+
+func recordsFromResourceRecordSets(recordSets []*route53.ResourceRecordSet) Records {
+	var records Records
+
+	for _, recordSet := range recordSets {
+		record := Record{
+			Entry:         entryName(*recordSet.Name),
+			Type:          *recordSet.Type,
+			TTL:           aws.Int64Value(recordSet.TTL),
+			SetIdentifier: aws.StringValue(recordSet.SetIdentifier),
+			Weight:        recordSet.Weight,
+			Region:        aws.StringValue(recordSet.Region),
+			Failover:      aws.StringValue(recordSet.Failover),
+			HealthCheckID: aws.StringValue(recordSet.HealthCheckId),
+		}
+
+		if recordSet.AliasTarget != nil {
+			record.Alias = &Alias{
+				HostedZoneID:         aws.StringValue(recordSet.AliasTarget.HostedZoneId),
+				DNSName:              entryName(aws.StringValue(recordSet.AliasTarget.DNSName)),
+				EvaluateTargetHealth: aws.BoolValue(recordSet.AliasTarget.EvaluateTargetHealth),
+			}
+		}
+
+		if recordSet.GeoLocation != nil {
+			record.GeoLocation = &GeoLocation{
+				ContinentCode:   aws.StringValue(recordSet.GeoLocation.ContinentCode),
+				CountryCode:     aws.StringValue(recordSet.GeoLocation.CountryCode),
+				SubdivisionCode: aws.StringValue(recordSet.GeoLocation.SubdivisionCode),
+			}
+		}
+
+		if recordSet.GeoProximityLocation != nil {
+			record.GeoProximity = &GeoProximity{
+				AWSRegion: aws.StringValue(recordSet.GeoProximityLocation.AWSRegion),
+				Bias:      aws.Int64Value(recordSet.GeoProximityLocation.Bias),
+			}
+			if coordinates := recordSet.GeoProximityLocation.Coordinates; coordinates != nil {
+				record.GeoProximity.Latitude = aws.StringValue(coordinates.Latitude)
+				record.GeoProximity.Longitude = aws.StringValue(coordinates.Longitude)
+			}
+		}
+
+		for _, rr := range recordSet.ResourceRecords {
+			value := *rr.Value
+			if record.Type == "TXT" || record.Type == "SPF" {
+				value = unquoteTXT(value)
+			}
+			record.Values = append(record.Values, value)
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// valueRenderers render values in the format route53 expects for their
+// record type, types without a renderer are sent as they are
+var valueRenderers = map[string]func(string) string{
+	"A":     normalizeIP,
+	"AAAA":  normalizeIP,
+	"TXT":   quoteTXT,
+	"SPF":   quoteTXT,
+	"MX":    normalizeFields,
+	"SRV":   normalizeFields,
+	"CAA":   renderCAA,
+	"NAPTR": strings.TrimSpace,
+}
+
+// renderValues builds the resource records for a record type's values, in
+// the order given as some resolvers answer round robin in that order
+func renderValues(recordType string, values []string) []*route53.ResourceRecord {
+	var records []*route53.ResourceRecord
+
+	render, ok := valueRenderers[recordType]
+	seen := make(map[string]bool)
+
+	for _, v := range values {
+		if ok {
+			v = render(v)
+		}
+
+		// route53 rejects a record set with the same value twice
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+
+		records = append(records, &route53.ResourceRecord{
+			Value: aws.String(v),
+		})
+	}
+
+	return records
+}
+
+func isQuoted(value string) bool {
+	return len(value) > 1 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`)
+}
+
+// normalizeIP renders an address in its canonical form, such as 2001:db8::1
+// for 2001:0db8:0:0:0:0:0:1
+func normalizeIP(value string) string {
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return value
+	}
+	return ip.String()
+}
+
+// normalizeFields separates the fields of a value with a single space
+func normalizeFields(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// renderCAA quotes the value of a caa record, as in: 0 issue "ca.example"
+func renderCAA(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return value
+	}
+
+	// the value follows the tag, and may itself contain spaces
+	v := strings.TrimSpace(value)
+	for _, f := range fields[:2] {
+		v = strings.TrimSpace(strings.TrimPrefix(v, f))
+	}
+
+	if !isQuoted(v) {
+		v = `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+	}
+
+	return fields[0] + " " + fields[1] + " " + v
+}
+
+// quoteTXT renders a txt value as quoted character strings of at most 255
+// characters, values that are already quoted are sent as they are
+func quoteTXT(value string) string {
+	if isQuoted(value) {
+		return value
+	}
+
+	var parts []string
+
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
+	parts = append(parts, value)
+
+	for i, part := range parts {
+		part = strings.Replace(part, `\`, `\\`, -1)
+		part = strings.Replace(part, `"`, `\"`, -1)
+		parts[i] = `"` + part + `"`
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// unquoteTXT joins the quoted character strings of a txt value
+func unquoteTXT(value string) string {
+	if !strings.HasPrefix(value, `"`) {
+		return value
+	}
+
+	var unquoted []byte
+	var quoted, escaped bool
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case escaped:
+			unquoted = append(unquoted, c)
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			unquoted = append(unquoted, c)
+		}
+	}
+
+	return string(unquoted)
+}
+
+func isDefaultRule(name string, record *route53.ResourceRecordSet) bool {
+	return sameName(*record.Name, name) && *record.Type == "SOA" ||
+		sameName(*record.Name, name) && *record.Type == "NS"
+}
+
+func isProtectedRule(ev *Event, record *route53.ResourceRecordSet) bool {
+	// the apex SOA record can never be removed from a zone
+	if ev.ManageDefaultRecords {
+		return sameName(*record.Name, ev.Name) && *record.Type == "SOA"
+	}
+	return isDefaultRule(ev.Name, record)
+}
+
+// isProtectedRecord returns true for records the event never lets
+// reconciliation remove
+func isProtectedRecord(ev *Event, record *route53.ResourceRecordSet) bool {
+	for _, name := range ev.ProtectedRecords {
+		if sameName(name, *record.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildRecordsToRemove(ev *Event, existing []*route53.ResourceRecordSet) []*route53.Change {
+	// Dont delete the default NS and SOA rules, unless the event manages them
+	// May conflict with non-default rules, needs testing
+
+	var missing []*route53.Change
+	var managed map[string]bool
+
+	ev.SkippedRecords = nil
+
+	if ev.OwnershipManifest {
+		managed = readManifest(ev, existing)
+	}
+
+	desired := make(map[string]bool)
+	for _, record := range ev.Records {
+		desired[recordSetKey(record.Entry, record.Type, record.SetIdentifier)] = true
+	}
+
+	for _, recordSet := range existing {
+		// record sets are told apart by name, type and set identifier, so a
+		// routing policy sibling that is no longer declared is removed while
+		// the others and simple records of the same name are kept
+		if desired[recordSetKey(*recordSet.Name, *recordSet.Type, aws.StringValue(recordSet.SetIdentifier))] {
+			continue
+		}
+
+		// records explicitly deleted by the event are handled separately
+		if ev.RecordsToDelete.HasRecordSet(recordSet) {
+			continue
+		}
+
+		if ev.OwnershipManifest && isManifest(ev, recordSet) {
+			continue
+		}
+
+		if isTrafficPolicyRecord(ev, recordSet) {
+			continue
+		}
+
+		if isProtectedRule(ev, recordSet) || isProtectedRecord(ev, recordSet) || ev.Replace && isDefaultRule(ev.Name, recordSet) {
+			ev.SkippedRecords = append(ev.SkippedRecords, *recordSet.Name)
+			continue
+		}
+
+		// leave records that were not created by the connector
+		if ev.OwnershipManifest && !ev.Replace && managed[manifestKey(*recordSet.Name, *recordSet.Type)] != true {
+			ev.SkippedRecords = append(ev.SkippedRecords, *recordSet.Name)
+			continue
+		}
+
+		missing = append(missing, &route53.Change{
+			Action:            aws.String("DELETE"),
+			ResourceRecordSet: recordSet,
+		})
+	}
+
+	return missing
+}
+
+func recordTTL(ev *Event, record Record) int64 {
+	ttl := record.TTL
+
+	if ttl == 0 {
+		ttl = ev.DefaultTTL
+		if ttl == 0 {
+			ttl = defaultTTL
+		}
+	}
+
+	// an event can lower the connector's maximum for its zone, never raise it
+	limit := maxTTL
+	if ev.MaxTTL > 0 && (limit == 0 || ev.MaxTTL < limit) {
+		limit = ev.MaxTTL
+	}
+
+	if limit > 0 && ttl > limit {
+		ev.logf(levelWarn, "Warning: ttl %d of record %s exceeds the maximum, using %d", ttl, record.Entry, limit)
+		return limit
+	}
+
+	return ttl
+}
+
+func buildResourceRecordSet(ev *Event, record Record) *route53.ResourceRecordSet {
+	recordSet := &route53.ResourceRecordSet{
+		Name: aws.String(canonicalName(record.Entry)),
+		Type: aws.String(record.Type),
+	}
+
+	if record.Alias != nil {
+		recordSet.AliasTarget = &route53.AliasTarget{
+			HostedZoneId:         aws.String(record.Alias.HostedZoneID),
+			DNSName:              aws.String(record.Alias.DNSName),
+			EvaluateTargetHealth: aws.Bool(record.Alias.EvaluateTargetHealth),
+		}
+	} else {
+		recordSet.TTL = aws.Int64(recordTTL(ev, record))
+		recordSet.ResourceRecords = renderValues(record.Type, record.Values)
+
+		if record.HealthCheckID != "" {
+			recordSet.HealthCheckId = aws.String(record.HealthCheckID)
+		}
+	}
+
+	if record.SetIdentifier != "" {
+		recordSet.SetIdentifier = aws.String(record.SetIdentifier)
+	}
+
+	if record.Region != "" {
+		recordSet.Region = aws.String(record.Region)
+	}
+
+	if record.Failover != "" {
+		recordSet.Failover = aws.String(record.Failover)
+	}
+
+	if record.GeoLocation != nil {
+		recordSet.GeoLocation = &route53.GeoLocation{}
+		if record.GeoLocation.ContinentCode != "" {
+			recordSet.GeoLocation.ContinentCode = aws.String(record.GeoLocation.ContinentCode)
+		}
+		if record.GeoLocation.CountryCode != "" {
+			recordSet.GeoLocation.CountryCode = aws.String(record.GeoLocation.CountryCode)
+		}
+		if record.GeoLocation.SubdivisionCode != "" {
+			recordSet.GeoLocation.SubdivisionCode = aws.String(record.GeoLocation.SubdivisionCode)
+		}
+	}
+
+	if record.GeoProximity != nil {
+		recordSet.GeoProximityLocation = &route53.GeoProximityLocation{
+			Bias: aws.Int64(record.GeoProximity.Bias),
+		}
+		if record.GeoProximity.AWSRegion != "" {
+			recordSet.GeoProximityLocation.AWSRegion = aws.String(record.GeoProximity.AWSRegion)
+		} else {
+			recordSet.GeoProximityLocation.Coordinates = &route53.Coordinates{
+				Latitude:  aws.String(record.GeoProximity.Latitude),
+				Longitude: aws.String(record.GeoProximity.Longitude),
+			}
+		}
+	}
+
+	recordSet.Weight = record.Weight
+
+	return recordSet
+}
+
+func buildChanges(ev *Event, existing []*route53.ResourceRecordSet) []*route53.Change {
+	var changes []*route53.Change
+
+	for _, record := range ev.Records {
+		current := findRecordSet(existing, record)
+		if current != nil {
+			record = mergeRecord(record, current)
+		}
+
+		recordSet := buildResourceRecordSet(ev, record)
+
+		// skip records that are already up to date
+		if current != nil && recordSetsEqual(recordSet, current) {
+			continue
+		}
+
+		changes = append(changes, &route53.Change{
+			Action:            aws.String("UPSERT"),
+			ResourceRecordSet: recordSet,
+		})
+	}
+
+	if ev.SOA != nil {
+		if change := buildSOAChange(ev, existing); change != nil {
+			changes = append(changes, change)
+		}
+	}
+
+	changes = append(changes, buildRecordsToDelete(ev, existing)...)
+
+	// append only zones never have records removed by reconciliation
+	if ev.AppendOnly != true {
+		changes = append(changes, buildRecordsToRemove(ev, existing)...)
+	}
+
+	if ev.OwnershipManifest {
+		if change := buildManifestChange(ev, existing); change != nil {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes
+}
+
+// buildTargetedChanges upserts only the record sets of the event, without
+// diffing the zone, so siblings in a weighted set are left untouched
+func buildTargetedChanges(ev *Event, existing []*route53.ResourceRecordSet) []*route53.Change {
+	var changes []*route53.Change
+
+	for _, record := range ev.Records {
+		changes = append(changes, &route53.Change{
+			Action:            aws.String("UPSERT"),
+			ResourceRecordSet: buildResourceRecordSet(ev, record),
+		})
+	}
+
+	return append(changes, buildRecordsToDelete(ev, existing)...)
+}
+
+// buildRecordsToDelete deletes the stored record sets of the records to
+// delete, as route53 only deletes a record set that matches exactly, skipping
+// those already gone or being upserted
+func buildRecordsToDelete(ev *Event, existing []*route53.ResourceRecordSet) []*route53.Change {
+	var changes []*route53.Change
+
+	for _, record := range ev.RecordsToDelete {
+		current := findRecordSet(existing, record)
+		if current == nil || ev.Records.HasRecordSet(current) {
+			continue
+		}
+
+		changes = append(changes, &route53.Change{
+			Action:            aws.String("DELETE"),
+			ResourceRecordSet: current,
+		})
+	}
+
+	return changes
+}
+
+// buildSOAChange updates the timers of the apex SOA record, keeping its
+// nameserver, contact and serial
+func buildSOAChange(ev *Event, existing []*route53.ResourceRecordSet) *route53.Change {
+	for _, recordSet := range existing {
+		if !sameName(*recordSet.Name, ev.Name) || *recordSet.Type != "SOA" || len(recordSet.ResourceRecords) != 1 {
+			continue
+		}
+
+		// mname rname serial refresh retry expire minimum
+		fields := strings.Fields(aws.StringValue(recordSet.ResourceRecords[0].Value))
+		if len(fields) != 7 {
+			ev.logf(levelWarn, "Warning: SOA record of zone %s is malformed, leaving it as it is", ev.Name)
+			return nil
+		}
+
+		value := fmt.Sprintf("%s %s %s %d %d %d %d", fields[0], fields[1], fields[2], ev.SOA.Refresh, ev.SOA.Retry, ev.SOA.Expire, ev.SOA.MinimumTTL)
+		if value == *recordSet.ResourceRecords[0].Value {
+			return nil
+		}
+
+		return &route53.Change{
+			Action: aws.String("UPSERT"),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            recordSet.Name,
+				Type:            recordSet.Type,
+				TTL:             recordSet.TTL,
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(value)}},
+			},
+		}
+	}
+
+	return nil
+}
+
+func checkPrivateZoneConflict(ev *Event) error {
+	svc := getRoute53ReadClient(ev)
+
+	req := &route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(ev.Name),
+	}
+
+	resp, err := svc.ListHostedZonesByName(req)
+	if err != nil {
+		return err
+	}
+
+	for _, zone := range resp.HostedZones {
+		if !sameName(*zone.Name, ev.Name) || zone.Config == nil || aws.BoolValue(zone.Config.PrivateZone) != true {
+			continue
+		}
+
+		// a replayed create of this zone is adopted rather than refused
+		if aws.StringValue(zone.CallerReference) == ev.CallerReference {
+			continue
+		}
+
+		hz, err := svc.GetHostedZone(&route53.GetHostedZoneInput{Id: zone.Id})
+		if err != nil {
+			return err
+		}
+
+		for _, vpc := range hz.VPCs {
+			for _, target := range ev.vpcs() {
+				if aws.StringValue(vpc.VPCId) == target.VPCID && aws.StringValue(vpc.VPCRegion) == target.VPCRegion {
+					return fmt.Errorf("Route53 private zone %s already exists for vpc %s as %s", ev.Name, target.VPCID, *zone.Id)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// changeSize counts the value characters of a change towards the request
+// limit, upserts count twice
+func changeSize(change *route53.Change) int {
+	var size int
+
+	for _, rr := range change.ResourceRecordSet.ResourceRecords {
+		size += len(aws.StringValue(rr.Value))
+	}
+
+	if aws.StringValue(change.Action) == "UPSERT" {
+		size *= 2
+	}
+
+	return size
+}
+
+// recordChanges counts the record sets a batch changes, a record set replaced
+// by a delete and a create counting once
+func recordChanges(batch []*route53.Change) int {
+	count := len(batch)
+
+	for i := 0; i+1 < len(batch); i++ {
+		if isReplacement(batch[i], batch[i+1]) {
+			count--
+			i++
+		}
+	}
+
+	return count
+}
+
+// isReplacement returns true for a delete followed by the create of the same
+// record set
+func isReplacement(del, create *route53.Change) bool {
+	if aws.StringValue(del.Action) != "DELETE" || aws.StringValue(create.Action) != "CREATE" {
+		return false
+	}
+
+	d, c := del.ResourceRecordSet, create.ResourceRecordSet
+
+	return recordSetKey(*d.Name, *d.Type, aws.StringValue(d.SetIdentifier)) == recordSetKey(*c.Name, *c.Type, aws.StringValue(c.SetIdentifier))
+}
+
+// batchChanges splits changes into requests within both the change count
+// and value size limits
+func batchChanges(changes []*route53.Change) [][]*route53.Change {
+	var batches [][]*route53.Change
+	var batch []*route53.Change
+	var size int
+
+	for i := 0; i < len(changes); i++ {
+		unit := changes[i : i+1]
+
+		// a record set is replaced by a delete and a create, which are kept
+		// in the same batch so the record set is never missing
+		if i+1 < len(changes) && isReplacement(changes[i], changes[i+1]) {
+			unit = changes[i : i+2]
+			i++
+		}
+
+		var cs int
+		for _, change := range unit {
+			cs += changeSize(change)
+		}
+
+		if len(batch) > 0 && (len(batch)+len(unit) > maxBatchChanges || size+cs > maxBatchValueSize) {
+			batches = append(batches, batch)
+			batch = nil
+			size = 0
+		}
+
+		batch = append(batch, unit...)
+		size += cs
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+func createRoute53(ev *Event) error {
+	svc := getRoute53Client(ev)
+
+	// a stable reference stops replayed events from creating duplicate zones
+	if ev.CallerReference == "" {
+		ev.CallerReference = uuid.NewV4().String()
+	}
+
+	req := &route53.CreateHostedZoneInput{
+		CallerReference: aws.String(ev.CallerReference),
+		Name:            aws.String(ev.Name),
+	}
+
+	req.HostedZoneConfig = hostedZoneConfig(ev)
+
+	if ev.Private == true {
+		if err := checkPrivateZoneConflict(ev); err != nil {
+			return err
+		}
+
+		vpc := ev.vpcs()[0]
+
+		req.VPC = &route53.VPC{
+			VPCId:     aws.String(vpc.VPCID),
+			VPCRegion: aws.String(vpc.VPCRegion),
+		}
+	}
+
+	resp, err := svc.CreateHostedZone(req)
+	switch {
+	case isZoneConflict(err):
+		if err := adoptZone(ev, err); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		ev.HostedZoneID = *resp.HostedZone.Id
+
+		// private zones are not delegated
+		if resp.DelegationSet != nil {
+			ev.NameServers = aws.StringValueSlice(resp.DelegationSet.NameServers)
+		}
+
+		if ev.Private == true {
+			if err := associateVPCs(ev, ev.vpcs()[1:]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := updateRecords(ev); err != nil {
+		return err
+	}
+
+	if err := reconcileTags(ev); err != nil {
+		return err
+	}
+
+	if err := applyTrafficPolicy(ev); err != nil {
+		return err
+	}
+
+	return readStoredRecords(ev)
+}
+
+// readStoredRecords replaces the records of a created zone's event with
+// those route53 stored, so the done event reports the zone as it is
+func readStoredRecords(ev *Event) error {
+	zr, err := getZoneRecords(ev)
+	if err != nil {
+		return err
+	}
+
+	setRecordCounts(ev, zr)
+
+	// records the connector keeps itself are left out, so the records can
+	// be sent back as they are in a later update
+	var stored []*route53.ResourceRecordSet
+	for _, recordSet := range zr {
+		if isProtectedRule(ev, recordSet) || isManifest(ev, recordSet) || isTrafficPolicyRecord(ev, recordSet) {
+			continue
+		}
+		stored = append(stored, recordSet)
+	}
+
+	ev.Records = recordsFromResourceRecordSets(stored)
+
+	return nil
+}
+
+// isZoneConflict returns true for errors of a zone that another create of
+// the same zone got to first
+func isZoneConflict(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == route53.ErrCodeHostedZoneAlreadyExists || aerr.Code() == route53.ErrCodeConflictingDomainExists
+	}
+	return false
+}
+
+// adoptZone carries on a create with the zone a replayed or concurrent create
+// of the same zone made, reporting the conflict when there is no zone with
+// the event's caller reference
+func adoptZone(ev *Event, conflict error) error {
+	svc := getRoute53ReadClient(ev)
+
+	resp, err := svc.ListHostedZonesByName(&route53.ListHostedZonesByNameInput{
+		DNSName: aws.String(ev.Name),
+	})
+	if err != nil {
+		return conflict
+	}
+
+	for _, zone := range resp.HostedZones {
+		if !sameName(*zone.Name, ev.Name) || aws.StringValue(zone.CallerReference) != ev.CallerReference {
+			continue
+		}
+
+		ev.HostedZoneID = *zone.Id
+		ev.logf(levelWarn, "Warning: zone %s was already created with caller reference %s, adopting %s", ev.Name, ev.CallerReference, ev.HostedZoneID)
+
+		if ev.Private == true {
+			return syncVPCs(ev)
+		}
+
+		return nil
+	}
+
+	return conflict
+}
+
+func syncVPCs(ev *Event) error {
+	svc := getRoute53Client(ev)
+
+	resp, err := svc.GetHostedZone(&route53.GetHostedZoneInput{
+		Id: aws.String(ev.HostedZoneID),
+	})
+	if err != nil {
+		return err
+	}
+
+	desired := ev.vpcs()
+	current := make(map[VPC]bool)
+
+	for _, vpc := range resp.VPCs {
+		current[VPC{VPCID: aws.StringValue(vpc.VPCId), VPCRegion: aws.StringValue(vpc.VPCRegion)}] = true
+	}
+
+	var missing []VPC
+	for _, vpc := range desired {
+		if !current[vpc.key()] {
+			missing = append(missing, vpc)
+		}
+		delete(current, vpc.key())
+	}
+
+	// associate first, as a private zone must keep at least one vpc
+	if err := associateVPCs(ev, missing); err != nil {
+		return err
+	}
+
+	for vpc := range current {
+		_, err := svc.DisassociateVPCFromHostedZone(&route53.DisassociateVPCFromHostedZoneInput{
+			HostedZoneId: aws.String(ev.HostedZoneID),
+			VPC: &route53.VPC{
+				VPCId:     aws.String(vpc.VPCID),
+				VPCRegion: aws.String(vpc.VPCRegion),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func associateVPCs(ev *Event, vpcs []VPC) error {
+	svc := getRoute53Client(ev)
+
+	for _, vpc := range vpcs {
+		if vpc.crossAccount() {
+			if err := associateCrossAccountVPC(ev, vpc); err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err := svc.AssociateVPCWithHostedZone(&route53.AssociateVPCWithHostedZoneInput{
+			HostedZoneId: aws.String(ev.HostedZoneID),
+			VPC: &route53.VPC{
+				VPCId:     aws.String(vpc.VPCID),
+				VPCRegion: aws.String(vpc.VPCRegion),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// associateCrossAccountVPC authorizes the vpc of another account in the
+// zone's account, then associates it from the vpc's account. The
+// authorization is only needed for the association, so it is removed after.
+func associateCrossAccountVPC(ev *Event, vpc VPC) error {
+	svc := getRoute53Client(ev)
+
+	target := &route53.VPC{
+		VPCId:     aws.String(vpc.VPCID),
+		VPCRegion: aws.String(vpc.VPCRegion),
+	}
+
+	_, err := svc.CreateVPCAssociationAuthorization(&route53.CreateVPCAssociationAuthorizationInput{
+		HostedZoneId: aws.String(ev.HostedZoneID),
+		VPC:          target,
+	})
+	if err != nil {
+		return err
+	}
+
+	vev := *ev
+	vev.DatacenterSecret = vpc.DatacenterSecret
+	vev.DatacenterToken = vpc.DatacenterToken
+
+	_, err = getRoute53Client(&vev).AssociateVPCWithHostedZone(&route53.AssociateVPCWithHostedZoneInput{
+		HostedZoneId: aws.String(ev.HostedZoneID),
+		VPC:          target,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteVPCAssociationAuthorization(&route53.DeleteVPCAssociationAuthorizationInput{
+		HostedZoneId: aws.String(ev.HostedZoneID),
+		VPC:          target,
+	})
+
+	return err
+}
+
+// hostedZoneConfig returns the config of the zone the event describes, or
+// nil for a public zone without a comment
+func hostedZoneConfig(ev *Event) *route53.HostedZoneConfig {
+	if ev.Private != true && ev.Comment == "" {
+		return nil
+	}
+
+	config := &route53.HostedZoneConfig{
+		PrivateZone: aws.Bool(ev.Private),
+	}
+
+	if ev.Comment != "" {
+		config.Comment = aws.String(ev.Comment)
+	}
+
+	return config
+}
+
+// reconcileZoneConfig updates the comment of the zone to the event's. Zones
+// can not change visibility once created, so that is refused instead.
+func reconcileZoneConfig(ev *Event) error {
+	svc := getRoute53ReadClient(ev)
+
+	resp, err := svc.GetHostedZone(&route53.GetHostedZoneInput{
+		Id: aws.String(ev.HostedZoneID),
+	})
+	if err != nil {
+		return err
+	}
+
+	var current route53.HostedZoneConfig
+	if resp.HostedZone.Config != nil {
+		current = *resp.HostedZone.Config
+	}
+
+	private := aws.BoolValue(current.PrivateZone)
+	if private != ev.Private {
+		visibility := map[bool]string{true: "private", false: "public"}
+		return fmt.Errorf("Route53 zone %s is %s and can not be made %s, the zone must be recreated", ev.Name, visibility[private], visibility[ev.Private])
+	}
+
+	// events without a comment leave the zone's as it is
+	if ev.Comment == "" || ev.Comment == aws.StringValue(current.Comment) {
+		return nil
+	}
+
+	_, err = getRoute53Client(ev).UpdateHostedZoneComment(&route53.UpdateHostedZoneCommentInput{
+		Id:      aws.String(ev.HostedZoneID),
+		Comment: aws.String(ev.Comment),
+	})
+
+	return err
+}
 
-	err := e.Process(m.Subject, m.Data)
+// checkEmptyUpdate refuses an update without records that would remove every
+// user record from the zone, unless the event confirms it
+func checkEmptyUpdate(ev *Event) error {
+	if len(ev.Records) > 0 || ev.ConfirmEmpty || ev.AppendOnly || ev.Targeted {
+		return nil
+	}
+
+	zr, err := getZoneRecords(ev)
 	if err != nil {
-		println(err.Error())
-		return
+		return err
 	}
 
-	if err = e.Validate(); err != nil {
-		e.Error(err)
-		return
+	var count int
+	for _, recordSet := range zr {
+		if !isDefaultRule(ev.Name, recordSet) && !isManifest(ev, recordSet) {
+			count++
+		}
 	}
 
-	parts := strings.Split(m.Subject, ".")
-	switch parts[1] {
-	case "create":
-		err = createRoute53(&e)
-	case "update":
-		err = updateRoute53(&e)
-	case "delete":
-		err = deleteRoute53(&e)
+	if count == 0 {
+		return nil
 	}
 
-	if err != nil {
-		e.Error(err)
-		return
+	return fmt.Errorf("Route53 update of zone %s has no records and would remove all %d of its records, set confirm_empty to allow it", ev.Name, count)
+}
+
+func updateRoute53(ev *Event) error {
+	if err := resolveZoneID(ev); err != nil {
+		return err
 	}
 
-	e.Complete()
+	if err := reconcileZoneConfig(ev); err != nil {
+		return err
+	}
+
+	if err := checkEmptyUpdate(ev); err != nil {
+		return err
+	}
+
+	if ev.Private == true {
+		if err := syncVPCs(ev); err != nil {
+			return err
+		}
+	}
+
+	if err := updateRecords(ev); err != nil {
+		return err
+	}
+
+	if err := reconcileTags(ev); err != nil {
+		return err
+	}
+
+	if err := applyTrafficPolicy(ev); err != nil {
+		return err
+	}
+
+	return countRecords(ev)
 }
 
-func getZoneRecords(ev *Event) ([]*route53.ResourceRecordSet, error) {
-	svc := getRoute53Client(ev)
+func updateRecords(ev *Event) error {
+	if ev.Targeted {
+		var zr []*route53.ResourceRecordSet
 
-	req := &route53.ListResourceRecordSetsInput{
-		HostedZoneId: aws.String(ev.HostedZoneID),
+		// the zone is only read to find the record sets to delete
+		if len(ev.RecordsToDelete) > 0 {
+			var err error
+			if zr, err = getZoneRecords(ev); err != nil {
+				return err
+			}
+		}
+
+		return applyChanges(ev, buildTargetedChanges(ev, zr), nil)
 	}
 
-	resp, err := svc.ListResourceRecordSets(req)
+	zr, err := getZoneRecords(ev)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return resp.ResourceRecordSets, nil
+	for attempt := 0; ; attempt++ {
+		err = applyChanges(ev, conditionalChanges(ev, buildChanges(ev, zr), zr), zr)
+		if !isInvalidChangeBatch(err) {
+			return err
+		}
+
+		// the batch is also rejected when a record set it deletes or creates
+		// was modified by another writer since the zone was read
+		current, lerr := getZoneRecords(ev)
+		if lerr != nil {
+			return lerr
+		}
+
+		if reflect.DeepEqual(zr, current) {
+			return err
+		}
+
+		if attempt >= maxConcurrentRetries {
+			return fmt.Errorf("%s: %s", ErrConcurrentModification, err)
+		}
+
+		ev.logf(levelInfo, "Info: zone %s was modified while applying its changes, building them again", ev.HostedZoneID)
+		zr = current
+	}
 }
 
-func buildResourceRecords(values []string) []*route53.ResourceRecord {
-	var records []*route53.ResourceRecord
+// conditionalChanges replaces the upserts of changes built from the existing
+// record sets with changes route53 rejects if the zone was modified since it
+// was read: new record sets are created, and existing ones are replaced by
+// deleting the exact set that was read and creating the new one. The apex
+// SOA and NS records can not be deleted, so are still upserted
+func conditionalChanges(ev *Event, changes []*route53.Change, existing []*route53.ResourceRecordSet) []*route53.Change {
+	var conditional []*route53.Change
 
-	for _, v := range values {
-		records = append(records, &route53.ResourceRecord{
-			Value: aws.String(v),
+	for _, change := range changes {
+		recordSet := change.ResourceRecordSet
+
+		if aws.StringValue(change.Action) != "UPSERT" || isDefaultRule(ev.Name, recordSet) {
+			conditional = append(conditional, change)
+			continue
+		}
+
+		current := findRecordSet(existing, Record{
+			Entry:         *recordSet.Name,
+			Type:          *recordSet.Type,
+			SetIdentifier: aws.StringValue(recordSet.SetIdentifier),
+		})
+		if current != nil {
+			conditional = append(conditional, &route53.Change{
+				Action:            aws.String("DELETE"),
+				ResourceRecordSet: current,
+			})
+		}
+
+		conditional = append(conditional, &route53.Change{
+			Action:            aws.String("CREATE"),
+			ResourceRecordSet: recordSet,
 		})
 	}
 
-	return records
+	return conditional
 }
 
-func isDefaultRule(name string, record *route53.ResourceRecordSet) bool {
-	return entryName(*record.Name) == entryName(name) && *record.Type == "SOA" ||
-		entryName(*record.Name) == entryName(name) && *record.Type == "NS"
+func isInvalidChangeBatch(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == route53.ErrCodeInvalidChangeBatch
+	}
+	return false
 }
 
-func buildRecordsToRemove(ev *Event, existing []*route53.ResourceRecordSet) []*route53.Change {
-	// Dont delete the default NS and SOA rules
-	// May conflict with non-default rules, needs testing
+// applyChanges applies the changes in batches, zr is the zone the changes
+// were built from
+func applyChanges(ev *Event, changes []*route53.Change, zr []*route53.ResourceRecordSet) error {
+	svc := getRoute53Client(ev)
 
-	var missing []*route53.Change
+	ev.AppliedBatches = 0
+	ev.FailedBatch = 0
 
-	for _, recordSet := range existing {
+	// the zone is left partially updated if a later batch fails
+	for i, batch := range batchChanges(changes) {
+		req := &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch: &route53.ChangeBatch{
+				Changes: batch,
+				Comment: aws.String(ev.changeComment()),
+			},
+			HostedZoneId: aws.String(ev.HostedZoneID),
+		}
 
-		if ev.Records.HasRecord(*recordSet.Name) != true && isDefaultRule(ev.Name, recordSet) != true {
-			missing = append(missing, &route53.Change{
-				Action:            aws.String("DELETE"),
-				ResourceRecordSet: recordSet,
-			})
+		ev.logf(levelDebug, "Debug: applying batch %d to zone %s: %s", i+1, ev.HostedZoneID, awsutil.Prettify(batch))
+
+		resp, err := svc.ChangeResourceRecordSets(req)
+		if err != nil {
+			ev.FailedBatch = i + 1
+
+			// the zone as it was before the changes, to diagnose the failure
+			if ev.Debug {
+				ev.ExistingRecords = recordsFromResourceRecordSets(zr)
+			}
+
+			return err
+		}
+
+		ev.AppliedBatches++
+		ev.ChangeCount += recordChanges(batch)
+		ev.ChangeIDs = append(ev.ChangeIDs, aws.StringValue(resp.ChangeInfo.Id))
+		ev.logf(levelDebug, "Debug: applied batch %d as change %s", i+1, aws.StringValue(resp.ChangeInfo.Id))
+
+		if ev.WaitForSync {
+			if err = waitForChange(ev, resp.ChangeInfo.Id); err != nil {
+				return err
+			}
 		}
 	}
 
-	return missing
+	return nil
 }
 
-func buildChanges(ev *Event, existing []*route53.ResourceRecordSet) []*route53.Change {
-	var changes []*route53.Change
+// pollInterval backs off exponentially between polls, with a little jitter
+// to keep clear of the GetChange rate limit
+func pollInterval(attempt uint) time.Duration {
+	interval := maxPollInterval
+	if attempt < 16 {
+		interval = minPollInterval << attempt
+	}
 
-	for _, record := range ev.Records {
-		changes = append(changes, &route53.Change{
-			Action: aws.String("UPSERT"),
-			ResourceRecordSet: &route53.ResourceRecordSet{
-				Name:            aws.String(record.Entry),
-				Type:            aws.String(record.Type),
-				TTL:             aws.Int64(record.TTL),
-				ResourceRecords: buildResourceRecords(record.Values),
-			},
-		})
+	if interval > maxPollInterval {
+		interval = maxPollInterval
 	}
 
-	changes = append(changes, buildRecordsToRemove(ev, existing)...)
+	interval += time.Duration(rand.Int63n(int64(interval)/10 + 1))
+	if interval > maxPollInterval {
+		interval = maxPollInterval
+	}
 
-	return changes
+	return interval
 }
 
-func createRoute53(ev *Event) error {
-	svc := getRoute53Client(ev)
+// waitForChange polls until route53 reports the change as INSYNC
+func waitForChange(ev *Event, id *string) error {
+	svc := getRoute53ReadClient(ev)
+	deadline := now().Add(syncTimeout)
 
-	req := &route53.CreateHostedZoneInput{
-		CallerReference: aws.String(uuid.NewV4().String()),
-		Name:            aws.String(ev.Name),
-	}
+	for attempt := uint(0); ; attempt++ {
+		resp, err := svc.GetChange(&route53.GetChangeInput{Id: id})
+		if err != nil {
+			return err
+		}
 
-	if ev.Private == true {
-		req.HostedZoneConfig = &route53.HostedZoneConfig{
-			PrivateZone: aws.Bool(ev.Private),
+		if aws.StringValue(resp.ChangeInfo.Status) == route53.ChangeStatusInsync {
+			return nil
 		}
-		req.VPC = &route53.VPC{
-			VPCId:     aws.String(ev.VPCID),
-			VPCRegion: aws.String(ev.DatacenterRegion),
+
+		interval := pollInterval(attempt)
+		if now().Add(interval).After(deadline) {
+			return ErrChangeTimeout
 		}
+
+		sleep(interval)
 	}
+}
 
-	resp, err := svc.CreateHostedZone(req)
+// upsertRoute53 upserts only the records of the event, never reading or
+// deleting anything else in the zone
+func upsertRoute53(ev *Event) error {
+	if err := checkAllowedZone(ev); err != nil {
+		return err
+	}
+
+	ev.RecordsToDelete = nil
+	ev.Targeted = true
+
+	return updateRecords(ev)
+}
+
+func deleteRoute53(ev *Event) error {
+	err := removeRoute53(ev)
+
+	// a zone that is already gone is not a failure, its health checks are
+	// still deleted in case an earlier attempt failed after deleting it
+	if err == ErrHostedZoneNotFound || isNoSuchHostedZone(err) {
+		ev.AlreadyDeleted = true
+		return deleteHealthChecks(ev)
+	}
+
+	return err
+}
+
+func removeRoute53(ev *Event) error {
+	if err := resolveZoneID(ev); err != nil {
+		return err
+	}
+
+	// the instance removes the record sets it created
+	if err := deleteTrafficPolicy(ev); err != nil {
+		return err
+	}
+
+	zr, err := getZoneRecords(ev)
 	if err != nil {
 		return err
 	}
 
-	ev.HostedZoneID = *resp.HostedZone.Id
+	// clear ruleset before delete, including protected records, the zone can
+	// not be deleted until the records are removed, so wait for the change to
+	// sync
+	ev.Records = nil
+	ev.ProtectedRecords = nil
+	ev.AppendOnly = false
+	ev.WaitForSync = true
+
+	// route53 removes the apex NS and SOA records with the zone itself
+	ev.ManageDefaultRecords = false
+
+	var removed []*route53.ResourceRecordSet
+	for _, change := range buildRecordsToRemove(ev, zr) {
+		removed = append(removed, change.ResourceRecordSet)
+	}
+
+	err = updateRecords(ev)
+	if err != nil {
+		return err
+	}
+
+	svc := getRoute53Client(ev)
+
+	req := &route53.DeleteHostedZoneInput{
+		Id: aws.String(ev.HostedZoneID),
+	}
+
+	_, err = svc.DeleteHostedZone(req)
+	if err != nil {
+		if !isNoSuchHostedZone(err) && len(removed) > 0 {
+			// the zone is left without its records, report them so the zone
+			// can be restored
+			ev.RemovedRecords = recordsFromResourceRecordSets(removed)
+			ev.logf(levelError, "Error: zone %s was not deleted after its records were removed: %s", ev.HostedZoneID, awsutil.Prettify(removed))
+
+			return fmt.Errorf("Route53 zone %s was not deleted but its %d records were already removed, the zone still exists: %s", ev.Name, len(removed), err.Error())
+		}
+		return err
+	}
 
-	return updateRoute53(ev)
+	// health checks are only deleted once the zone is gone, so the records
+	// it could be restored with still point at them
+	return deleteHealthChecks(ev)
 }
 
-func updateRoute53(ev *Event) error {
+// deleteHealthChecks deletes the health checks the event owns, skipping
+// those that are already gone
+func deleteHealthChecks(ev *Event) error {
+	if len(ev.OwnedHealthChecks) == 0 {
+		return nil
+	}
+
 	svc := getRoute53Client(ev)
 
+	for _, id := range ev.OwnedHealthChecks {
+		_, err := svc.DeleteHealthCheck(&route53.DeleteHealthCheckInput{
+			HealthCheckId: aws.String(id),
+		})
+
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == route53.ErrCodeNoSuchHealthCheck {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ev.logf(levelInfo, "Info: deleted health check %s", id)
+	}
+
+	return nil
+}
+
+func isNoSuchHostedZone(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == route53.ErrCodeNoSuchHostedZone
+	}
+	return false
+}
+
+func getRoute53(ev *Event) error {
+	if err := resolveZoneID(ev); err != nil {
+		return err
+	}
+
 	zr, err := getZoneRecords(ev)
 	if err != nil {
 		return err
 	}
 
-	req := &route53.ChangeResourceRecordSetsInput{
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: buildChanges(ev, zr),
-		},
-		HostedZoneId: aws.String(ev.HostedZoneID),
+	ev.Records = recordsFromResourceRecordSets(zr)
+	setRecordCounts(ev, zr)
+
+	return nil
+}
+
+// getZoneStatus reads the details of a zone along with its records, without
+// making any changes
+func getZoneStatus(ev *Event) error {
+	if err := getRoute53(ev); err != nil {
+		return err
 	}
 
-	_, err = svc.ChangeResourceRecordSets(req)
+	svc := getRoute53ReadClient(ev)
+
+	resp, err := svc.GetHostedZone(&route53.GetHostedZoneInput{
+		Id: aws.String(ev.HostedZoneID),
+	})
 	if err != nil {
 		return err
 	}
 
-	return err
+	ev.Name = entryName(*resp.HostedZone.Name)
+	ev.Private = false
+	ev.Comment = ""
+	ev.VPCs = nil
+	ev.NameServers = nil
+
+	if resp.HostedZone.Config != nil {
+		ev.Private = aws.BoolValue(resp.HostedZone.Config.PrivateZone)
+		ev.Comment = aws.StringValue(resp.HostedZone.Config.Comment)
+	}
+
+	for _, vpc := range resp.VPCs {
+		ev.VPCs = append(ev.VPCs, VPC{
+			VPCID:     aws.StringValue(vpc.VPCId),
+			VPCRegion: aws.StringValue(vpc.VPCRegion),
+		})
+	}
+
+	// private zones are not delegated
+	if resp.DelegationSet != nil {
+		ev.NameServers = aws.StringValueSlice(resp.DelegationSet.NameServers)
+	}
+
+	return nil
 }
 
-func deleteRoute53(ev *Event) error {
-	// clear ruleset before delete
-	ev.Records = nil
-	err := updateRoute53(ev)
+// countRecords reports the size of the zone once changes have been applied
+func countRecords(ev *Event) error {
+	zr, err := getZoneRecords(ev)
 	if err != nil {
 		return err
 	}
 
-	svc := getRoute53Client(ev)
+	setRecordCounts(ev, zr)
 
-	req := &route53.DeleteHostedZoneInput{
-		Id: aws.String(ev.HostedZoneID),
+	return nil
+}
+
+func setRecordCounts(ev *Event, recordSets []*route53.ResourceRecordSet) {
+	ev.RecordSetCount = len(recordSets)
+	ev.ResourceRecordCount = 0
+
+	for _, recordSet := range recordSets {
+		// an alias answers with a single record
+		if recordSet.AliasTarget != nil {
+			ev.ResourceRecordCount++
+			continue
+		}
+		ev.ResourceRecordCount += len(recordSet.ResourceRecords)
 	}
+}
 
-	_, err = svc.DeleteHostedZone(req)
+// authErrorCodes are the aws error codes returned for credentials that are
+// invalid, expired or not allowed to use route53
+var authErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+	"ExpiredToken":                true,
+}
+
+// verifyCredentials makes a cheap call with the event's credentials, so a
+// bad key fails before any changes are attempted
+func verifyCredentials(ev *Event) error {
+	svc := getRoute53Client(ev)
+
+	_, err := svc.GetHostedZoneCount(&route53.GetHostedZoneCountInput{})
+	if aerr, ok := err.(awserr.Error); ok && authErrorCodes[aerr.Code()] {
+		return ErrDatacenterCredentialsInvalid
+	}
 
 	return err
 }
 
-func getRoute53Client(ev *Event) *route53.Route53 {
+// getRoute53Client returns the client used for an event's aws calls
+var getRoute53Client = newRoute53Client
+
+// getRoute53ReadClient returns the client used for read only calls, built
+// from the event's read credentials when it has them
+func getRoute53ReadClient(ev *Event) route53iface.Route53API {
+	if ev.ReadDatacenterSecret == "" || ev.ReadDatacenterToken == "" {
+		return getRoute53Client(ev)
+	}
+
+	rev := *ev
+	rev.DatacenterSecret = ev.ReadDatacenterSecret
+	rev.DatacenterToken = ev.ReadDatacenterToken
+
+	return getRoute53Client(&rev)
+}
+
+// clientRegion returns the region for route53 calls, as route53 is a global
+// service the default region is used when the event has none, and the
+// partition's own region outside the standard partition
+func clientRegion(ev *Event) string {
+	if p := partitions[ev.partition()]; p.region != "" {
+		return p.region
+	}
+	if ev.DatacenterRegion != "" {
+		return ev.DatacenterRegion
+	}
+	return defaultRegion
+}
+
+func newRoute53Client(ev *Event) route53iface.Route53API {
+	config := clientConfig(ev, clientRegion(ev))
+
+	if p := partitions[ev.partition()]; p.endpoint != "" {
+		config = config.WithEndpoint(p.endpoint)
+	}
+
+	return route53.New(newSession(ev), config)
+}
+
+// newSession returns an aws session for the event, naming its datacenter in
+// the user agent so calls can be traced back to it in cloudtrail
+func newSession(ev *Event) *session.Session {
+	sess := session.New()
+
+	if ev.DatacenterName != "" {
+		sess.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler("datacenter/" + ev.DatacenterName))
+	}
+
+	sess.Handlers.Complete.PushBackNamed(auditHandler(ev))
+
+	return sess
+}
+
+// clientConfig returns the aws config for an event's credentials
+func clientConfig(ev *Event, region string) *aws.Config {
 	creds := credentials.NewStaticCredentials(ev.DatacenterSecret, ev.DatacenterToken, "")
-	return route53.New(session.New(), &aws.Config{
-		Region:      aws.String(ev.DatacenterRegion),
+	config := &aws.Config{
+		Region:      aws.String(region),
 		Credentials: creds,
-	})
+		MaxRetries:  aws.Int(maxRetries),
+		HTTPClient:  &http.Client{Timeout: httpTimeout},
+	}
+
+	if ev.budget != nil {
+		// the sdk only asks the retryer about requests no handler marked
+		// retryable, every retry has to be taken from the budget
+		config.EnforceShouldRetryCheck = aws.Bool(true)
+		config = request.WithRetryer(config, budgetRetryer{
+			DefaultRetryer: client.DefaultRetryer{
+				NumMaxRetries:    maxRetries,
+				MaxRetryDelay:    maxRetryDelay,
+				MaxThrottleDelay: maxRetryDelay,
+			},
+			budget: ev.budget,
+		})
+	}
+
+	return config
+}
+
+// getEnvInt returns the integer value of an environment variable, or the
+// fallback when it is unset or invalid
+func getEnvInt(name string, fallback int64) int64 {
+	if os.Getenv(name) == "" {
+		return fallback
+	}
+
+	v, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		logf(levelError, "Error: invalid %s, using %d: %s", name, fallback, err.Error())
+		return fallback
+	}
+
+	return v
+}
+
+// envRegion returns the region set in the environment, read the same way
+// as the aws sdk
+func envRegion() string {
+	if os.Getenv("AWS_REGION") != "" {
+		return os.Getenv("AWS_REGION")
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func getDefaultRegion() string {
+	if region := envRegion(); region != "" {
+		return region
+	}
+	return "us-east-1"
 }
 
 func main() {
-	nc = ecc.NewConfig(os.Getenv("NATS_URI")).Nats()
+	logLevel = getLogLevel()
+
+	var err error
+	nc, err = connectNats(os.Getenv("NATS_URI"))
+	if err != nil {
+		logf(levelError, "Error: could not connect to nats: %s", err.Error())
+		os.Exit(1)
+	}
+
+	maxTTL = getEnvInt("MAX_TTL", 0)
+	minRecordTTL = getEnvInt("MIN_RECORD_TTL", 1)
+	maxRecordTTL = getEnvInt("MAX_RECORD_TTL", 604800)
+	maxVPCAssociations = int(getEnvInt("MAX_VPC_ASSOCIATIONS", 300))
+	maxRecords = int(getEnvInt("MAX_RECORDS", 10000))
+	maxEventRecords = int(getEnvInt("MAX_EVENT_RECORDS", 10000))
+	maxRetries = int(getEnvInt("AWS_MAX_RETRIES", 3))
+	maxConcurrentRetries = int(getEnvInt("CONCURRENT_RETRIES", 3))
+	httpTimeout = time.Duration(getEnvInt("AWS_HTTP_TIMEOUT", 30)) * time.Second
+	maxEventRetries = int(getEnvInt("RETRY_BUDGET", 20))
+	retryBudgetTimeout = time.Duration(getEnvInt("RETRY_BUDGET_TIMEOUT", 120)) * time.Second
+	maxRetryDelay = time.Duration(getEnvInt("AWS_MAX_RETRY_DELAY", 20)) * time.Second
+	coalesceWindow = time.Duration(getEnvInt("COALESCE_WINDOW_MS", 0)) * time.Millisecond
+	allowedZones = parseAllowedZones(os.Getenv("ALLOWED_ZONES"))
+	defaultRegion = getDefaultRegion()
+	audit = publishAudit
 
 	fmt.Println("listening for route53.create.aws")
 	nc.Subscribe("route53.create.aws", eventHandler)
@@ -212,8 +1830,35 @@ func main() {
 	fmt.Println("listening for route53.update.aws")
 	nc.Subscribe("route53.update.aws", eventHandler)
 
+	fmt.Println("listening for route53.upsert.aws")
+	nc.Subscribe("route53.upsert.aws", eventHandler)
+
 	fmt.Println("listening for route53.delete.aws")
 	nc.Subscribe("route53.delete.aws", eventHandler)
 
+	fmt.Println("listening for route53.get.aws")
+	nc.Subscribe("route53.get.aws", eventHandler)
+
+	fmt.Println("listening for route53.export.aws")
+	nc.Subscribe("route53.export.aws", eventHandler)
+
+	fmt.Println("listening for route53.list.aws")
+	nc.Subscribe("route53.list.aws", eventHandler)
+
+	fmt.Println("listening for route53.diff.aws")
+	nc.Subscribe("route53.diff.aws", eventHandler)
+
+	fmt.Println("listening for route53.import.aws")
+	nc.Subscribe("route53.import.aws", eventHandler)
+
+	fmt.Println("listening for route53.validate.aws")
+	nc.Subscribe("route53.validate.aws", eventHandler)
+
+	fmt.Println("listening for route53_resolver.create.aws")
+	nc.Subscribe("route53_resolver.create.aws", eventHandler)
+
+	fmt.Println("listening for route53_resolver.delete.aws")
+	nc.Subscribe("route53_resolver.delete.aws", eventHandler)
+
 	runtime.Goexit()
 }
@@ -0,0 +1,2500 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testRoute53Client struct {
+	route53iface.Route53API
+	zones                  []*route53.HostedZone
+	vpcs                   map[string][]*route53.VPC
+	records                []*route53.ResourceRecordSet
+	created                []*route53.CreateHostedZoneInput
+	changes                []*route53.ChangeResourceRecordSetsInput
+	comments               []*route53.UpdateHostedZoneCommentInput
+	associated             []*route53.AssociateVPCWithHostedZoneInput
+	disassociated          []*route53.DisassociateVPCFromHostedZoneInput
+	authorizations         []string
+	deleted                []string
+	healthChecks           []string
+	deletedHealthChecks    []string
+	policyInstances        []*route53.TrafficPolicyInstance
+	deletedPolicyInstances []string
+	delegationSet          *route53.DelegationSet
+	tags                   map[string]string
+	tagChanges             []*route53.ChangeTagsForResourceInput
+	countErr               error
+	createErr              error
+	deleteErr              error
+	// pageSize paginates record set listings when set
+	pageSize int
+	// onList is called with the count of record set listings, before each
+	onList func(n int)
+	listed int
+	// failChange fails the nth change request when set
+	failChange int
+	// onChange is called with the count of change requests, failing the
+	// request with the error it returns
+	onChange func(n int) error
+	// pending is the number of polls before a change is INSYNC
+	pending int
+	polls   int
+}
+
+func (c *testRoute53Client) CreateHostedZone(in *route53.CreateHostedZoneInput) (*route53.CreateHostedZoneOutput, error) {
+	c.created = append(c.created, in)
+	if c.createErr != nil {
+		return nil, c.createErr
+	}
+	zone := &route53.HostedZone{
+		Id:              aws.String("/hostedzone/CREATED"),
+		Name:            in.Name,
+		Config:          in.HostedZoneConfig,
+		CallerReference: in.CallerReference,
+	}
+	c.zones = append(c.zones, zone)
+	return &route53.CreateHostedZoneOutput{HostedZone: zone, DelegationSet: c.delegationSet}, nil
+}
+
+func (c *testRoute53Client) GetHostedZone(in *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+	for _, zone := range c.zones {
+		if *zone.Id == *in.Id {
+			return &route53.GetHostedZoneOutput{HostedZone: zone, VPCs: c.vpcs[*zone.Id], DelegationSet: c.delegationSet}, nil
+		}
+	}
+	return nil, errors.New("no such hosted zone")
+}
+
+func (c *testRoute53Client) UpdateHostedZoneComment(in *route53.UpdateHostedZoneCommentInput) (*route53.UpdateHostedZoneCommentOutput, error) {
+	c.comments = append(c.comments, in)
+	return &route53.UpdateHostedZoneCommentOutput{}, nil
+}
+
+func (c *testRoute53Client) ChangeResourceRecordSets(in *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	c.changes = append(c.changes, in)
+	if len(c.changes) == c.failChange {
+		return nil, errors.New("change failed")
+	}
+	if c.onChange != nil {
+		if err := c.onChange(len(c.changes)); err != nil {
+			return nil, err
+		}
+	}
+	info := &route53.ChangeInfo{Id: aws.String("/change/TEST"), Status: aws.String(route53.ChangeStatusPending)}
+	return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: info}, nil
+}
+
+func (c *testRoute53Client) AssociateVPCWithHostedZone(in *route53.AssociateVPCWithHostedZoneInput) (*route53.AssociateVPCWithHostedZoneOutput, error) {
+	c.associated = append(c.associated, in)
+	c.vpcs[*in.HostedZoneId] = append(c.vpcs[*in.HostedZoneId], in.VPC)
+	return &route53.AssociateVPCWithHostedZoneOutput{}, nil
+}
+
+func (c *testRoute53Client) CreateVPCAssociationAuthorization(in *route53.CreateVPCAssociationAuthorizationInput) (*route53.CreateVPCAssociationAuthorizationOutput, error) {
+	c.authorizations = append(c.authorizations, *in.VPC.VPCId)
+	return &route53.CreateVPCAssociationAuthorizationOutput{HostedZoneId: in.HostedZoneId, VPC: in.VPC}, nil
+}
+
+func (c *testRoute53Client) DeleteVPCAssociationAuthorization(in *route53.DeleteVPCAssociationAuthorizationInput) (*route53.DeleteVPCAssociationAuthorizationOutput, error) {
+	for i, id := range c.authorizations {
+		if id == *in.VPC.VPCId {
+			c.authorizations = append(c.authorizations[:i], c.authorizations[i+1:]...)
+			return &route53.DeleteVPCAssociationAuthorizationOutput{}, nil
+		}
+	}
+	return nil, awserr.New(route53.ErrCodeVPCAssociationAuthorizationNotFound, "no such authorization", nil)
+}
+
+func (c *testRoute53Client) DisassociateVPCFromHostedZone(in *route53.DisassociateVPCFromHostedZoneInput) (*route53.DisassociateVPCFromHostedZoneOutput, error) {
+	c.disassociated = append(c.disassociated, in)
+	return &route53.DisassociateVPCFromHostedZoneOutput{}, nil
+}
+
+func (c *testRoute53Client) GetChange(in *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+	c.polls++
+	status := route53.ChangeStatusInsync
+	if c.polls <= c.pending {
+		status = route53.ChangeStatusPending
+	}
+	return &route53.GetChangeOutput{ChangeInfo: &route53.ChangeInfo{Id: in.Id, Status: aws.String(status)}}, nil
+}
+
+func (c *testRoute53Client) ListHostedZonesByName(in *route53.ListHostedZonesByNameInput) (*route53.ListHostedZonesByNameOutput, error) {
+	return &route53.ListHostedZonesByNameOutput{HostedZones: c.zones}, nil
+}
+
+func (c *testRoute53Client) ListHostedZones(in *route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+	start := 0
+	if in.Marker != nil {
+		for start < len(c.zones) && *c.zones[start].Id != *in.Marker {
+			start++
+		}
+	}
+
+	end := start + c.pageSize
+	if c.pageSize == 0 || end >= len(c.zones) {
+		return &route53.ListHostedZonesOutput{HostedZones: c.zones[start:], IsTruncated: aws.Bool(false)}, nil
+	}
+
+	return &route53.ListHostedZonesOutput{
+		HostedZones: c.zones[start:end],
+		IsTruncated: aws.Bool(true),
+		NextMarker:  c.zones[end].Id,
+	}, nil
+}
+
+func (c *testRoute53Client) ListResourceRecordSets(in *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	if c.zones != nil && !c.hasZone(*in.HostedZoneId) {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "no such hosted zone", nil)
+	}
+	c.listed++
+	if c.onList != nil {
+		c.onList(c.listed)
+	}
+	if c.pageSize == 0 {
+		return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: c.records}, nil
+	}
+
+	start := 0
+	if in.StartRecordName != nil {
+		for start < len(c.records) && (*c.records[start].Name != *in.StartRecordName || *c.records[start].Type != *in.StartRecordType) {
+			start++
+		}
+	}
+
+	end := start + c.pageSize
+	if end >= len(c.records) {
+		return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: c.records[start:]}, nil
+	}
+
+	return &route53.ListResourceRecordSetsOutput{
+		ResourceRecordSets: c.records[start:end],
+		IsTruncated:        aws.Bool(true),
+		NextRecordName:     c.records[end].Name,
+		NextRecordType:     c.records[end].Type,
+	}, nil
+}
+
+func (c *testRoute53Client) DeleteHostedZone(in *route53.DeleteHostedZoneInput) (*route53.DeleteHostedZoneOutput, error) {
+	if !c.hasZone(*in.Id) {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "no such hosted zone", nil)
+	}
+	if len(c.changes) > 0 && c.polls <= c.pending {
+		return nil, awserr.New(route53.ErrCodeHostedZoneNotEmpty, "hosted zone not empty", nil)
+	}
+	if c.deleteErr != nil {
+		return nil, c.deleteErr
+	}
+	c.deleted = append(c.deleted, *in.Id)
+	return &route53.DeleteHostedZoneOutput{}, nil
+}
+
+func (c *testRoute53Client) DeleteHealthCheck(in *route53.DeleteHealthCheckInput) (*route53.DeleteHealthCheckOutput, error) {
+	for i, id := range c.healthChecks {
+		if id == *in.HealthCheckId {
+			c.healthChecks = append(c.healthChecks[:i], c.healthChecks[i+1:]...)
+			c.deletedHealthChecks = append(c.deletedHealthChecks, id)
+			return &route53.DeleteHealthCheckOutput{}, nil
+		}
+	}
+	return nil, awserr.New(route53.ErrCodeNoSuchHealthCheck, "no such health check", nil)
+}
+
+func (c *testRoute53Client) ListTrafficPolicyInstancesByHostedZone(in *route53.ListTrafficPolicyInstancesByHostedZoneInput) (*route53.ListTrafficPolicyInstancesByHostedZoneOutput, error) {
+	return &route53.ListTrafficPolicyInstancesByHostedZoneOutput{TrafficPolicyInstances: c.policyInstances, IsTruncated: aws.Bool(false)}, nil
+}
+
+func (c *testRoute53Client) CreateTrafficPolicyInstance(in *route53.CreateTrafficPolicyInstanceInput) (*route53.CreateTrafficPolicyInstanceOutput, error) {
+	instance := &route53.TrafficPolicyInstance{
+		Id:                   aws.String("instance-1"),
+		HostedZoneId:         in.HostedZoneId,
+		Name:                 in.Name,
+		TTL:                  in.TTL,
+		TrafficPolicyId:      in.TrafficPolicyId,
+		TrafficPolicyVersion: in.TrafficPolicyVersion,
+	}
+	c.policyInstances = append(c.policyInstances, instance)
+	return &route53.CreateTrafficPolicyInstanceOutput{TrafficPolicyInstance: instance}, nil
+}
+
+func (c *testRoute53Client) UpdateTrafficPolicyInstance(in *route53.UpdateTrafficPolicyInstanceInput) (*route53.UpdateTrafficPolicyInstanceOutput, error) {
+	for _, instance := range c.policyInstances {
+		if *instance.Id == *in.Id {
+			instance.TTL = in.TTL
+			instance.TrafficPolicyVersion = in.TrafficPolicyVersion
+			return &route53.UpdateTrafficPolicyInstanceOutput{TrafficPolicyInstance: instance}, nil
+		}
+	}
+	return nil, awserr.New(route53.ErrCodeNoSuchTrafficPolicyInstance, "no such traffic policy instance", nil)
+}
+
+func (c *testRoute53Client) DeleteTrafficPolicyInstance(in *route53.DeleteTrafficPolicyInstanceInput) (*route53.DeleteTrafficPolicyInstanceOutput, error) {
+	for i, instance := range c.policyInstances {
+		if *instance.Id == *in.Id {
+			c.policyInstances = append(c.policyInstances[:i], c.policyInstances[i+1:]...)
+			c.deletedPolicyInstances = append(c.deletedPolicyInstances, *in.Id)
+			return &route53.DeleteTrafficPolicyInstanceOutput{}, nil
+		}
+	}
+	return nil, awserr.New(route53.ErrCodeNoSuchTrafficPolicyInstance, "no such traffic policy instance", nil)
+}
+
+func (c *testRoute53Client) ListTagsForResource(in *route53.ListTagsForResourceInput) (*route53.ListTagsForResourceOutput, error) {
+	set := &route53.ResourceTagSet{ResourceId: in.ResourceId, ResourceType: in.ResourceType}
+	for key, value := range c.tags {
+		set.Tags = append(set.Tags, &route53.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return &route53.ListTagsForResourceOutput{ResourceTagSet: set}, nil
+}
+
+func (c *testRoute53Client) ChangeTagsForResource(in *route53.ChangeTagsForResourceInput) (*route53.ChangeTagsForResourceOutput, error) {
+	c.tagChanges = append(c.tagChanges, in)
+	if c.tags == nil {
+		c.tags = make(map[string]string)
+	}
+	for _, tag := range in.AddTags {
+		c.tags[*tag.Key] = *tag.Value
+	}
+	for _, key := range in.RemoveTagKeys {
+		delete(c.tags, *key)
+	}
+	return &route53.ChangeTagsForResourceOutput{}, nil
+}
+
+func (c *testRoute53Client) GetHostedZoneCount(in *route53.GetHostedZoneCountInput) (*route53.GetHostedZoneCountOutput, error) {
+	if c.countErr != nil {
+		return nil, c.countErr
+	}
+	return &route53.GetHostedZoneCountOutput{HostedZoneCount: aws.Int64(int64(len(c.zones)))}, nil
+}
+
+func (c *testRoute53Client) hasZone(id string) bool {
+	for _, zone := range c.zones {
+		if *zone.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// testCallRecorder records the vpc association calls made with each
+// account's credentials
+type testCallRecorder struct {
+	*testRoute53Client
+	secret string
+	calls  *[]string
+}
+
+func (r *testCallRecorder) record(call string) {
+	*r.calls = append(*r.calls, r.secret+" "+call)
+}
+
+func (r *testCallRecorder) CreateHostedZone(in *route53.CreateHostedZoneInput) (*route53.CreateHostedZoneOutput, error) {
+	r.record("CreateHostedZone")
+	return r.testRoute53Client.CreateHostedZone(in)
+}
+
+func (r *testCallRecorder) CreateVPCAssociationAuthorization(in *route53.CreateVPCAssociationAuthorizationInput) (*route53.CreateVPCAssociationAuthorizationOutput, error) {
+	r.record("CreateVPCAssociationAuthorization " + *in.VPC.VPCId)
+	return r.testRoute53Client.CreateVPCAssociationAuthorization(in)
+}
+
+func (r *testCallRecorder) AssociateVPCWithHostedZone(in *route53.AssociateVPCWithHostedZoneInput) (*route53.AssociateVPCWithHostedZoneOutput, error) {
+	r.record("AssociateVPCWithHostedZone " + *in.VPC.VPCId)
+	return r.testRoute53Client.AssociateVPCWithHostedZone(in)
+}
+
+func (r *testCallRecorder) DeleteVPCAssociationAuthorization(in *route53.DeleteVPCAssociationAuthorizationInput) (*route53.DeleteVPCAssociationAuthorizationOutput, error) {
+	r.record("DeleteVPCAssociationAuthorization " + *in.VPC.VPCId)
+	return r.testRoute53Client.DeleteVPCAssociationAuthorization(in)
+}
+
+func testClient(c *testRoute53Client) {
+	getRoute53Client = func(ev *Event) route53iface.Route53API {
+		return c
+	}
+}
+
+func TestBuildChanges(t *testing.T) {
+	Convey("Given an event with records", t, func() {
+		e := testEvent
+		e.Records = Records{
+			{Entry: "long.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 864000000},
+			{Entry: "short.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+		}
+
+		Convey("With a maximum ttl configured", func() {
+			log.SetOutput(ioutil.Discard)
+			maxTTL = 3600
+			changes := buildChanges(&e, nil)
+			maxTTL = 0
+			log.SetOutput(os.Stdout)
+
+			Convey("It should clamp ttls above the maximum", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 3600)
+			})
+
+			Convey("It should not modify ttls below the maximum", func() {
+				So(*changes[1].ResourceRecordSet.TTL, ShouldEqual, 300)
+			})
+		})
+
+		Convey("With a maximum ttl on the event", func() {
+			log.SetOutput(ioutil.Discard)
+			e.Records[0].TTL = 86400
+			e.MaxTTL = 3600
+			changes := buildChanges(&e, nil)
+			log.SetOutput(os.Stdout)
+
+			Convey("It should clamp ttls above the event maximum", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 3600)
+				So(*changes[1].ResourceRecordSet.TTL, ShouldEqual, 300)
+			})
+		})
+
+		Convey("With a maximum ttl on the event above the configured one", func() {
+			log.SetOutput(ioutil.Discard)
+			maxTTL = 3600
+			e.MaxTTL = 7200
+			changes := buildChanges(&e, nil)
+			maxTTL = 0
+			log.SetOutput(os.Stdout)
+
+			Convey("It should keep the configured maximum", func() {
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 3600)
+			})
+		})
+
+		Convey("With a record that has no ttl", func() {
+			e.Records = append(e.Records, Record{Entry: "none.test", Type: "A", Values: []string{"10.0.0.3"}})
+
+			Convey("When the event has no default ttl", func() {
+				changes := buildChanges(&e, nil)
+
+				Convey("It should apply the connector default ttl", func() {
+					So(len(changes), ShouldEqual, 3)
+					So(*changes[2].ResourceRecordSet.TTL, ShouldEqual, 300)
+				})
+			})
+
+			Convey("When the event has a default ttl", func() {
+				e.DefaultTTL = 60
+				changes := buildChanges(&e, nil)
+
+				Convey("It should apply the event default ttl", func() {
+					So(len(changes), ShouldEqual, 3)
+					So(*changes[2].ResourceRecordSet.TTL, ShouldEqual, 60)
+				})
+
+				Convey("It should not modify records with a ttl", func() {
+					So(*changes[1].ResourceRecordSet.TTL, ShouldEqual, 300)
+				})
+			})
+		})
+
+		Convey("With an enum naptr record", func() {
+			e.Records = Records{
+				{Entry: "4.3.2.1.5.5.5.0.0.8.1.e164.test", Type: "NAPTR", Values: []string{`100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`}, TTL: 300},
+			}
+			changes := buildChanges(&e, nil)
+
+			Convey("It should send the value unmodified", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].ResourceRecordSet.Type, ShouldEqual, "NAPTR")
+				So(*changes[0].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, `100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`)
+			})
+		})
+
+		Convey("With a weighted record referencing a health check", func() {
+			weight := int64(10)
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 60, SetIdentifier: "blue", Weight: &weight, HealthCheckID: "abcdef11-2222-3333-4444-555555fedcba"},
+			}
+			changes := buildChanges(&e, nil)
+
+			Convey("It should forward the health check id", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].ResourceRecordSet.Weight, ShouldEqual, 10)
+				So(*changes[0].ResourceRecordSet.HealthCheckId, ShouldEqual, "abcdef11-2222-3333-4444-555555fedcba")
+			})
+		})
+
+		Convey("With spf and txt records sharing a long value", func() {
+			value := "v=spf1 " + strings.Repeat("include:spf.example.com ", 15) + "-all"
+			e.Records = Records{
+				{Entry: "spf.test", Type: "SPF", Values: []string{value}, TTL: 300},
+				{Entry: "txt.test", Type: "TXT", Values: []string{value}, TTL: 300},
+			}
+			changes := buildChanges(&e, nil)
+
+			Convey("It should quote and chunk the spf value like the txt value", func() {
+				So(len(changes), ShouldEqual, 2)
+				spf := *changes[0].ResourceRecordSet.ResourceRecords[0].Value
+				So(spf, ShouldEqual, *changes[1].ResourceRecordSet.ResourceRecords[0].Value)
+				So(spf, ShouldEqual, `"`+value[:255]+`" "`+value[255:]+`"`)
+			})
+		})
+
+		Convey("With weighted alias records to s3 websites in two regions", func() {
+			west, central := int64(50), int64(50)
+			e.Records = Records{
+				{Entry: "static.test", Type: "A", SetIdentifier: "eu-west-1", Weight: &west, Alias: &Alias{HostedZoneID: "Z1BKCTXD74EZPE", DNSName: "s3-website-eu-west-1.amazonaws.com"}},
+				{Entry: "static.test", Type: "A", SetIdentifier: "eu-central-1", Weight: &central, Alias: &Alias{HostedZoneID: "Z21DNDUVLTQW6Q", DNSName: "s3-website.eu-central-1.amazonaws.com"}},
+			}
+			err := e.Validate()
+			changes := buildChanges(&e, nil)
+
+			Convey("It should render a weighted alias record set for each", func() {
+				So(err, ShouldBeNil)
+				So(len(changes), ShouldEqual, 2)
+
+				for i, region := range []string{"eu-west-1", "eu-central-1"} {
+					rs := changes[i].ResourceRecordSet
+					So(*changes[i].Action, ShouldEqual, "UPSERT")
+					So(*rs.Name, ShouldEqual, "static.test.")
+					So(*rs.SetIdentifier, ShouldEqual, region)
+					So(*rs.Weight, ShouldEqual, 50)
+					So(*rs.AliasTarget.HostedZoneId, ShouldEqual, e.Records[i].Alias.HostedZoneID)
+					So(*rs.AliasTarget.DNSName, ShouldEqual, e.Records[i].Alias.DNSName)
+					So(rs.TTL, ShouldBeNil)
+					So(rs.ResourceRecords, ShouldBeNil)
+				}
+			})
+		})
+
+		Convey("With alias records evaluating target health differently", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Alias: &Alias{HostedZoneID: "Z32O12XQLNTSW2", DNSName: "web-123.eu-west-1.elb.amazonaws.com", EvaluateTargetHealth: true}},
+				{Entry: "static.test", Type: "A", Alias: &Alias{HostedZoneID: "Z1BKCTXD74EZPE", DNSName: "s3-website-eu-west-1.amazonaws.com"}},
+			}
+			changes := buildChanges(&e, nil)
+
+			Convey("It should set target health evaluation per record", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.AliasTarget.EvaluateTargetHealth, ShouldBeTrue)
+				So(*changes[0].ResourceRecordSet.AliasTarget.HostedZoneId, ShouldEqual, "Z32O12XQLNTSW2")
+				So(*changes[1].ResourceRecordSet.AliasTarget.EvaluateTargetHealth, ShouldBeFalse)
+				So(*changes[1].ResourceRecordSet.AliasTarget.DNSName, ShouldEqual, "s3-website-eu-west-1.amazonaws.com")
+			})
+		})
+
+		Convey("With entries supplied without a trailing dot", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			existing := []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A")},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should send the entry in its canonical form", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+			})
+
+			Convey("It should match the existing dotted record", func() {
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, *existing[0].Name)
+			})
+		})
+
+		Convey("With a record to delete from a multi record zone", func() {
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+			}
+			e.RecordsToDelete = Records{
+				{Entry: "old.test", Type: "A", Values: []string{"10.0.0.3"}, TTL: 300},
+			}
+			existing := []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("SOA")},
+				{Name: aws.String("test."), Type: aws.String("NS")},
+				{Name: aws.String("www.test."), Type: aws.String("A")},
+				{Name: aws.String("api.test."), Type: aws.String("A")},
+				{Name: aws.String("old.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}}},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should only delete the targeted record", func() {
+				So(len(changes), ShouldEqual, 3)
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[1].Action, ShouldEqual, "UPSERT")
+				So(*changes[2].Action, ShouldEqual, "DELETE")
+				So(*changes[2].ResourceRecordSet.Name, ShouldEqual, "old.test.")
+				So(*changes[2].ResourceRecordSet.TTL, ShouldEqual, 300)
+				So(*changes[2].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.3")
+			})
+		})
+
+		Convey("With records to delete that differ from the stored ones", func() {
+			log.SetOutput(ioutil.Discard)
+			maxTTL = 3600
+			Reset(func() {
+				maxTTL = 0
+				log.SetOutput(os.Stdout)
+			})
+
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			e.RecordsToDelete = Records{
+				{Entry: "OLD.test", Type: "A", Values: []string{"10.0.0.3"}},
+				{Entry: "gone.test", Type: "A", Values: []string{"10.0.0.4"}, TTL: 300},
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			existing := []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.9")}}},
+				{Name: aws.String("old.test."), Type: aws.String("A"), TTL: aws.Int64(86400), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}}},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should delete the stored record set as it is", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[1].Action, ShouldEqual, "DELETE")
+				So(changes[1].ResourceRecordSet, ShouldEqual, existing[1])
+				So(*changes[1].ResourceRecordSet.TTL, ShouldEqual, 86400)
+			})
+
+			Convey("It should skip records that are absent or being upserted", func() {
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+			})
+		})
+
+		Convey("With no maximum ttl configured", func() {
+			changes := buildChanges(&e, nil)
+
+			Convey("It should use the supplied ttls", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 864000000)
+				So(*changes[1].ResourceRecordSet.TTL, ShouldEqual, 300)
+			})
+		})
+	})
+}
+
+func testZoneRecords() []*route53.ResourceRecordSet {
+	return []*route53.ResourceRecordSet{
+		{Name: aws.String("test."), Type: aws.String("SOA")},
+		{Name: aws.String("test."), Type: aws.String("NS")},
+		{Name: aws.String("www.test."), Type: aws.String("A")},
+	}
+}
+
+func TestBuildSOAChange(t *testing.T) {
+	Convey("Given a zone with an apex SOA record", t, func() {
+		e := testEvent
+		e.Records = Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+		}
+		existing := []*route53.ResourceRecordSet{
+			{Name: aws.String("test."), Type: aws.String("SOA"), TTL: aws.Int64(900), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-01.org. awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400")}}},
+			{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+		}
+
+		Convey("When the event has no SOA override", func() {
+			changes := buildChanges(&e, existing)
+
+			Convey("It should leave the SOA record as it is", func() {
+				So(len(changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the event overrides the SOA timers", func() {
+			e.SOA = &SOA{Refresh: 3600, Retry: 600, Expire: 604800, MinimumTTL: 300}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should upsert the SOA with the new timers", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[0].ResourceRecordSet.Type, ShouldEqual, "SOA")
+				So(*changes[0].ResourceRecordSet.TTL, ShouldEqual, 900)
+				So(*changes[0].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "ns-1.awsdns-01.org. awsdns-hostmaster.amazon.com. 1 3600 600 604800 300")
+			})
+		})
+
+		Convey("When the SOA already has the overridden timers", func() {
+			e.SOA = &SOA{Refresh: 7200, Retry: 900, Expire: 1209600, MinimumTTL: 86400}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should not emit any changes", func() {
+				So(len(changes), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestBuildRecordsToRemove(t *testing.T) {
+	Convey("Given a zone with default and user records", t, func() {
+		e := testEvent
+		e.Records = nil
+		existing := testZoneRecords()
+
+		Convey("When the event is append only", func() {
+			e.AppendOnly = true
+			e.Records = Records{
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should not remove records missing from the event", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "api.test.")
+			})
+		})
+
+		Convey("When the event does not manage default records", func() {
+			changes := buildRecordsToRemove(&e, existing)
+
+			Convey("It should only remove the user records", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "DELETE")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+			})
+
+			Convey("It should report the skipped apex records", func() {
+				So(e.SkippedRecords, ShouldResemble, []string{"test.", "test."})
+			})
+		})
+
+		Convey("When the event protects a record it omits", func() {
+			e.ProtectedRecords = []string{"WWW.test"}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should not remove the protected record", func() {
+				for _, c := range changes {
+					So(*c.ResourceRecordSet.Name, ShouldNotEqual, "www.test.")
+				}
+			})
+
+			Convey("It should report the protected record as skipped", func() {
+				So(e.SkippedRecords, ShouldResemble, []string{"test.", "test.", "www.test."})
+			})
+		})
+
+		Convey("When the event manages default records", func() {
+			e.ManageDefaultRecords = true
+			changes := buildRecordsToRemove(&e, existing)
+
+			Convey("It should remove the apex NS and user records", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.Type, ShouldEqual, "NS")
+				So(*changes[1].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+			})
+
+			Convey("It should not remove the apex SOA", func() {
+				for _, c := range changes {
+					So(*c.ResourceRecordSet.Type, ShouldNotEqual, "SOA")
+				}
+			})
+
+			Convey("It should only report the apex SOA as skipped", func() {
+				So(e.SkippedRecords, ShouldResemble, []string{"test."})
+			})
+		})
+
+		Convey("When the event replaces a zone with weighted records", func() {
+			blue, green := int64(50), int64(50)
+			existing = append(existing,
+				&route53.ResourceRecordSet{Name: aws.String("api.test."), Type: aws.String("A"), SetIdentifier: aws.String("blue"), Weight: &blue, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				&route53.ResourceRecordSet{Name: aws.String("api.test."), Type: aws.String("A"), SetIdentifier: aws.String("green"), Weight: &green, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}}},
+			)
+			e.Replace = true
+			e.ManageDefaultRecords = true
+			e.Records = Records{
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 60, SetIdentifier: "blue", Weight: &blue},
+			}
+			changes := buildRecordsToRemove(&e, existing)
+
+			Convey("It should remove the sibling no longer declared", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+				So(*changes[1].ResourceRecordSet.Name, ShouldEqual, "api.test.")
+				So(*changes[1].ResourceRecordSet.SetIdentifier, ShouldEqual, "green")
+			})
+
+			Convey("It should keep the apex SOA and NS records", func() {
+				So(e.SkippedRecords, ShouldResemble, []string{"test.", "test."})
+			})
+		})
+
+		Convey("When the zone and its apex record are named differently", func() {
+			existing = append(existing,
+				&route53.ResourceRecordSet{Name: aws.String("test."), Type: aws.String("TXT"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"v=spf1 -all"`)}}},
+			)
+			e.Name = "Test"
+			e.Records = Records{
+				{Entry: "test.", Type: "TXT", Values: []string{"v=spf1 -all"}, TTL: 300},
+				{Entry: "WWW.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should preserve the apex record and the default records", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].Action, ShouldEqual, "UPSERT")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "WWW.test.")
+				So(e.SkippedRecords, ShouldResemble, []string{"test.", "test."})
+			})
+		})
+
+		Convey("When the event declares simple records and some weighted siblings", func() {
+			blue, green, red := int64(40), int64(40), int64(20)
+			existing = append(existing,
+				&route53.ResourceRecordSet{Name: aws.String("api.test."), Type: aws.String("A"), SetIdentifier: aws.String("blue"), Weight: &blue, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				&route53.ResourceRecordSet{Name: aws.String("api.test."), Type: aws.String("A"), SetIdentifier: aws.String("green"), Weight: &green, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}}},
+				&route53.ResourceRecordSet{Name: aws.String("api.test."), Type: aws.String("A"), SetIdentifier: aws.String("red"), Weight: &red, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}}},
+			)
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.4"}, TTL: 300},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 60, SetIdentifier: "blue", Weight: &blue},
+				{Entry: "api.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 60, SetIdentifier: "green", Weight: &green},
+			}
+			changes := buildRecordsToRemove(&e, existing)
+
+			Convey("It should only remove the sibling no longer declared", func() {
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "api.test.")
+				So(*changes[0].ResourceRecordSet.SetIdentifier, ShouldEqual, "red")
+			})
+		})
+	})
+}
+
+func TestGetRoute53(t *testing.T) {
+	Convey("Given an existing zone", t, func() {
+		testClient(&testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/PRIVATE"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+				{Id: aws.String("/hostedzone/PUBLIC"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+			records: []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-01.com.")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}, {Value: aws.String("10.0.0.2")}}},
+			},
+		})
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When getting the zone by name", func() {
+			e := testEvent
+			err := getRoute53(&e)
+
+			Convey("It should resolve the hosted zone id", func() {
+				So(err, ShouldBeNil)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/PUBLIC")
+			})
+
+			Convey("It should return the zone records", func() {
+				So(len(e.Records), ShouldEqual, 2)
+				So(e.Records[1].Entry, ShouldEqual, "www.test")
+				So(e.Records[1].Type, ShouldEqual, "A")
+				So(e.Records[1].TTL, ShouldEqual, 300)
+				So(e.Records[1].Values, ShouldResemble, []string{"10.0.0.1", "10.0.0.2"})
+			})
+		})
+
+		Convey("When getting an unknown zone by name", func() {
+			e := testEvent
+			e.Name = "unknown"
+			err := getRoute53(&e)
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrHostedZoneNotFound)
+			})
+		})
+	})
+}
+
+func TestGetZoneStatus(t *testing.T) {
+	Convey("Given a private zone", t, func() {
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true), Comment: aws.String("internal services")}},
+			},
+			vpcs: map[string][]*route53.VPC{
+				"/hostedzone/TEST": {{VPCId: aws.String("vpc-00000000"), VPCRegion: aws.String("eu-west-1")}},
+			},
+			records: []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("SOA"), TTL: aws.Int64(900), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.test. hostmaster.test. 1 7200 900 1209600 86400")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}, {Value: aws.String("10.0.0.2")}}},
+			},
+			delegationSet: &route53.DelegationSet{NameServers: aws.StringSlice([]string{"ns-1.awsdns-01.org", "ns-2.awsdns-02.com"})},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When getting the status of the zone by name", func() {
+			e := testEvent
+			e.Records = nil
+			e.Private = true
+			err := getZoneStatus(&e)
+
+			Convey("It should return the zone details", func() {
+				So(err, ShouldBeNil)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/TEST")
+				So(e.Private, ShouldBeTrue)
+				So(e.Comment, ShouldEqual, "internal services")
+				So(e.VPCs, ShouldResemble, []VPC{{VPCID: "vpc-00000000", VPCRegion: "eu-west-1"}})
+				So(e.NameServers, ShouldResemble, []string{"ns-1.awsdns-01.org", "ns-2.awsdns-02.com"})
+			})
+
+			Convey("It should return the records and their counts", func() {
+				So(len(e.Records), ShouldEqual, 2)
+				So(e.RecordSetCount, ShouldEqual, 2)
+				So(e.ResourceRecordCount, ShouldEqual, 3)
+			})
+
+			Convey("It should not make any changes", func() {
+				So(len(c.changes), ShouldEqual, 0)
+				So(len(c.created), ShouldEqual, 0)
+				So(len(c.associated), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestRecordsFromResourceRecordSets(t *testing.T) {
+	Convey("Given records of several types", t, func() {
+		e := testEvent
+		e.Records = Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}, TTL: 300},
+			{Entry: "test", Type: "TXT", Values: []string{strings.Repeat("a", 300), `say "hi"`, "v=spf1 -all"}, TTL: 60},
+			{Entry: "test", Type: "MX", Values: []string{"10 mail.test"}, TTL: 3600},
+			{Entry: "cdn.test", Type: "A", Alias: &Alias{HostedZoneID: "Z2FDTNDATAQYW2", DNSName: "d111111abcdef8.cloudfront.net"}},
+			{Entry: "elb.test", Type: "A", Alias: &Alias{HostedZoneID: "Z32O12XQLNTSW2", DNSName: "web-123.eu-west-1.elb.amazonaws.com", EvaluateTargetHealth: true}},
+			{Entry: "api.test", Type: "CNAME", Values: []string{"eu.api.test"}, TTL: 60, SetIdentifier: "eu", Weight: aws.Int64(10)},
+			{Entry: "geo.test", Type: "A", Values: []string{"10.0.0.3"}, TTL: 60, SetIdentifier: "europe", GeoLocation: &GeoLocation{ContinentCode: "EU"}},
+			{Entry: "near.test", Type: "A", Values: []string{"10.0.0.4"}, TTL: 60, SetIdentifier: "ireland", GeoProximity: &GeoProximity{AWSRegion: "eu-west-1", Bias: 25}},
+			{Entry: "near.test", Type: "A", Values: []string{"10.0.0.5"}, TTL: 60, SetIdentifier: "london", GeoProximity: &GeoProximity{Latitude: "51.50", Longitude: "-0.12", Bias: -10}},
+		}
+
+		Convey("When building the changes", func() {
+			var recordSets []*route53.ResourceRecordSet
+			for _, c := range buildChanges(&e, nil) {
+				recordSets = append(recordSets, c.ResourceRecordSet)
+			}
+
+			Convey("It should quote txt values", func() {
+				So(*recordSets[1].ResourceRecords[0].Value, ShouldEqual, `"`+strings.Repeat("a", 255)+`" "`+strings.Repeat("a", 45)+`"`)
+				So(*recordSets[1].ResourceRecords[1].Value, ShouldEqual, `"say \"hi\""`)
+				So(*recordSets[1].ResourceRecords[2].Value, ShouldEqual, `"v=spf1 -all"`)
+			})
+
+			Convey("It should not set a ttl on alias records", func() {
+				So(recordSets[3].TTL, ShouldBeNil)
+				So(recordSets[3].ResourceRecords, ShouldBeNil)
+				So(*recordSets[3].AliasTarget.DNSName, ShouldEqual, "d111111abcdef8.cloudfront.net")
+				So(*recordSets[4].AliasTarget.EvaluateTargetHealth, ShouldBeTrue)
+			})
+
+			Convey("And converting them back into records", func() {
+				records := recordsFromResourceRecordSets(recordSets)
+
+				Convey("It should match the original records", func() {
+					So(records, ShouldResemble, e.Records)
+				})
+			})
+		})
+
+		Convey("When converting record sets stored by route53", func() {
+			records := recordsFromResourceRecordSets([]*route53.ResourceRecordSet{
+				{
+					Name: aws.String("cdn.test."),
+					Type: aws.String("A"),
+					AliasTarget: &route53.AliasTarget{
+						HostedZoneId:         aws.String("Z2FDTNDATAQYW2"),
+						DNSName:              aws.String("d111111abcdef8.cloudfront.net."),
+						EvaluateTargetHealth: aws.Bool(false),
+					},
+				},
+			})
+
+			Convey("It should strip the trailing dots", func() {
+				So(records[0].Entry, ShouldEqual, "cdn.test")
+				So(records[0].Alias.DNSName, ShouldEqual, "d111111abcdef8.cloudfront.net")
+				So(records[0].TTL, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestCreateRoute53(t *testing.T) {
+	Convey("Given a private zone event", t, func() {
+		e := testEvent
+		e.Private = true
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/EXISTING"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+			},
+			vpcs: map[string][]*route53.VPC{
+				"/hostedzone/EXISTING": {
+					{VPCId: aws.String("vpc-11111111"), VPCRegion: aws.String("eu-west-1")},
+				},
+			},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When a private zone with the same name exists for the vpc", func() {
+			c.vpcs["/hostedzone/EXISTING"] = append(c.vpcs["/hostedzone/EXISTING"], &route53.VPC{
+				VPCId:     aws.String("vpc-00000000"),
+				VPCRegion: aws.String("eu-west-1"),
+			})
+			err := createRoute53(&e)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 private zone test already exists for vpc vpc-00000000 as /hostedzone/EXISTING")
+			})
+
+			Convey("It should not create the zone", func() {
+				So(len(c.created), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a private zone with the same name exists for another vpc", func() {
+			err := createRoute53(&e)
+
+			Convey("It should create the zone", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/CREATED")
+			})
+		})
+
+		Convey("When the zone is associated with several vpcs", func() {
+			e.VPCs = []VPC{
+				{VPCID: "vpc-22222222"},
+				{VPCID: "vpc-33333333", VPCRegion: "us-east-1"},
+			}
+			err := createRoute53(&e)
+
+			Convey("It should create the zone with the first vpc", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(*c.created[0].VPC.VPCId, ShouldEqual, "vpc-00000000")
+			})
+
+			Convey("It should associate the other vpcs", func() {
+				So(len(c.associated), ShouldEqual, 2)
+				So(*c.associated[0].VPC.VPCId, ShouldEqual, "vpc-22222222")
+				So(*c.associated[0].VPC.VPCRegion, ShouldEqual, "eu-west-1")
+				So(*c.associated[1].VPC.VPCId, ShouldEqual, "vpc-33333333")
+				So(*c.associated[1].VPC.VPCRegion, ShouldEqual, "us-east-1")
+			})
+		})
+
+		Convey("When the zone is associated with a vpc of another account", func() {
+			var calls []string
+			getRoute53Client = func(ev *Event) route53iface.Route53API {
+				return &testCallRecorder{testRoute53Client: c, secret: ev.DatacenterSecret, calls: &calls}
+			}
+
+			e.VPCs = []VPC{
+				{VPCID: "vpc-22222222", DatacenterSecret: "other", DatacenterToken: "token"},
+			}
+			err := createRoute53(&e)
+
+			Convey("It should authorize the vpc before associating it from its own account", func() {
+				So(err, ShouldBeNil)
+				So(calls, ShouldResemble, []string{
+					"key CreateHostedZone",
+					"key CreateVPCAssociationAuthorization vpc-22222222",
+					"other AssociateVPCWithHostedZone vpc-22222222",
+					"key DeleteVPCAssociationAuthorization vpc-22222222",
+				})
+				So(len(c.associated), ShouldEqual, 1)
+				So(len(c.authorizations), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the event supplies a caller reference", func() {
+			e.CallerReference = "service-1234"
+			err := createRoute53(&e)
+
+			Convey("It should create the zone with the supplied reference", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(*c.created[0].CallerReference, ShouldEqual, "service-1234")
+			})
+		})
+
+		Convey("When the event has no caller reference", func() {
+			err := createRoute53(&e)
+
+			Convey("It should generate a caller reference", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(*c.created[0].CallerReference, ShouldNotBeEmpty)
+				So(e.CallerReference, ShouldEqual, *c.created[0].CallerReference)
+			})
+		})
+	})
+
+	Convey("Given a public zone event", t, func() {
+		e := testEvent
+		e.Records = Records{
+			{Entry: "WWW.Test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+		}
+
+		c := &testRoute53Client{
+			delegationSet: &route53.DelegationSet{NameServers: aws.StringSlice([]string{"ns-1.awsdns-01.org"})},
+		}
+		c.onList = func(n int) {
+			// route53 stores the records of the zone once they are applied
+			if len(c.changes) > 0 {
+				c.records = []*route53.ResourceRecordSet{
+					{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-01.org.")}}},
+					{Name: aws.String("test."), Type: aws.String("SOA"), TTL: aws.Int64(900), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-01.org. awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400")}}},
+					{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				}
+			}
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When the zone is created", func() {
+			err := createRoute53(&e)
+
+			Convey("It should report the records as route53 stored them", func() {
+				So(err, ShouldBeNil)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/CREATED")
+				So(e.NameServers, ShouldResemble, []string{"ns-1.awsdns-01.org"})
+				So(e.Records, ShouldResemble, Records{
+					{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+				})
+				So(e.RecordSetCount, ShouldEqual, 3)
+			})
+		})
+	})
+
+	Convey("Given a zone created concurrently by another event", t, func() {
+		log.SetOutput(ioutil.Discard)
+		e := testEvent
+		e.CallerReference = "service-1234"
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/RACED"), Name: aws.String("test."), CallerReference: aws.String("service-1234"), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+			createErr: awserr.New(route53.ErrCodeHostedZoneAlreadyExists, "hosted zone already exists", nil),
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+			log.SetOutput(os.Stdout)
+		})
+
+		Convey("When creating the zone conflicts", func() {
+			err := createRoute53(&e)
+
+			Convey("It should adopt the existing zone and apply the records to it", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/RACED")
+				So(len(c.changes), ShouldEqual, 1)
+				So(*c.changes[0].HostedZoneId, ShouldEqual, "/hostedzone/RACED")
+				So(*c.changes[0].ChangeBatch.Changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+			})
+		})
+
+		Convey("When the conflicting zone was created with another caller reference", func() {
+			c.zones[0].CallerReference = aws.String("other-5678")
+			err := createRoute53(&e)
+
+			Convey("It should report the conflict without adopting the zone", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, route53.ErrCodeHostedZoneAlreadyExists)
+				So(e.HostedZoneID, ShouldEqual, "")
+				So(len(c.changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a private create is replayed", func() {
+			e.Private = true
+			c.zones[0].Config.PrivateZone = aws.Bool(true)
+			c.vpcs = map[string][]*route53.VPC{
+				"/hostedzone/RACED": {
+					{VPCId: aws.String("vpc-00000000"), VPCRegion: aws.String("eu-west-1")},
+				},
+			}
+			err := createRoute53(&e)
+
+			Convey("It should adopt the zone it created before", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/RACED")
+				So(len(c.changes), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When creating the zone conflicts without a zone to adopt", func() {
+			c.zones = nil
+			err := createRoute53(&e)
+
+			Convey("It should report the conflict", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, route53.ErrCodeHostedZoneAlreadyExists)
+				So(len(c.changes), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestUpdateRoute53(t *testing.T) {
+	Convey("Given an event with changes split across three batches", t, func() {
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+		e.Records = Records{
+			{Entry: "a.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			{Entry: "b.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+			{Entry: "c.test", Type: "A", Values: []string{"10.0.0.3"}, TTL: 300},
+		}
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+		}
+		testClient(c)
+		maxBatchChanges = 1
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+			maxBatchChanges = 1000
+		})
+
+		Convey("When all batches are applied", func() {
+			err := updateRoute53(&e)
+
+			Convey("It should apply every batch", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 3)
+				So(e.AppliedBatches, ShouldEqual, 3)
+				So(e.FailedBatch, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the zone is modified between reading it and applying the changes", func() {
+			maxBatchChanges = 1000
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("a.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.9")}}},
+			}
+			c.onChange = func(n int) error {
+				// another writer changes the record before the first batch
+				if n == 1 {
+					c.records = []*route53.ResourceRecordSet{
+						{Name: aws.String("a.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.5")}}},
+					}
+					return awserr.New(route53.ErrCodeInvalidChangeBatch, "record set not found", nil)
+				}
+				return nil
+			}
+			err := updateRoute53(&e)
+
+			Convey("It should replace the record set only as it was read", func() {
+				changes := c.changes[0].ChangeBatch.Changes
+				So(*changes[0].Action, ShouldEqual, "DELETE")
+				So(*changes[0].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.9")
+				So(*changes[1].Action, ShouldEqual, "CREATE")
+				So(*changes[2].Action, ShouldEqual, "CREATE")
+			})
+
+			Convey("It should build the changes again from the modified zone and retry", func() {
+				So(err, ShouldBeNil)
+				So(c.listed, ShouldEqual, 3)
+				So(len(c.changes), ShouldEqual, 2)
+				changes := c.changes[1].ChangeBatch.Changes
+				So(len(changes), ShouldEqual, 4)
+				So(*changes[0].Action, ShouldEqual, "DELETE")
+				So(*changes[0].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.5")
+				So(*changes[1].Action, ShouldEqual, "CREATE")
+				So(*changes[1].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.1")
+			})
+		})
+
+		Convey("When the zone keeps being modified", func() {
+			maxConcurrentRetries = 2
+			Reset(func() {
+				maxConcurrentRetries = 3
+			})
+			c.onChange = func(n int) error {
+				c.records = append(c.records, &route53.ResourceRecordSet{
+					Name: aws.String(fmt.Sprintf("r%d.test.", n)), Type: aws.String("A"), TTL: aws.Int64(300),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.4")}},
+				})
+				return awserr.New(route53.ErrCodeInvalidChangeBatch, "record set already exists", nil)
+			}
+			err := updateRoute53(&e)
+
+			Convey("It should give up after retrying", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, ErrConcurrentModification.Error()+": ")
+				So(err.Error(), ShouldContainSubstring, "record set already exists")
+				So(len(c.changes), ShouldEqual, 3)
+				So(c.listed, ShouldEqual, 4)
+			})
+		})
+
+		Convey("When a batch is rejected without the zone being modified", func() {
+			c.onChange = func(n int) error {
+				return awserr.New(route53.ErrCodeInvalidChangeBatch, "invalid value", nil)
+			}
+			err := updateRoute53(&e)
+
+			Convey("It should report the error without retrying", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "invalid value")
+				So(len(c.changes), ShouldEqual, 1)
+				So(c.listed, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the event has no change comment", func() {
+			err := updateRoute53(&e)
+
+			Convey("It should comment each batch with the event uuid and batch id", func() {
+				So(err, ShouldBeNil)
+				for _, change := range c.changes {
+					So(*change.ChangeBatch.Comment, ShouldEqual, "event test of batch test")
+				}
+			})
+		})
+
+		Convey("When the event has a change comment", func() {
+			e.ChangeComment = "deploy 42"
+			err := updateRoute53(&e)
+
+			Convey("It should comment each batch with it", func() {
+				So(err, ShouldBeNil)
+				So(*c.changes[0].ChangeBatch.Comment, ShouldEqual, "deploy 42")
+			})
+		})
+
+		Convey("When the event only names the zone", func() {
+			e.HostedZoneID = ""
+			err := updateRoute53(&e)
+
+			Convey("It should resolve the hosted zone id by name", func() {
+				So(err, ShouldBeNil)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/TEST")
+				So(*c.changes[0].HostedZoneId, ShouldEqual, "/hostedzone/TEST")
+			})
+		})
+
+		Convey("When the name matches several zones of the same scope", func() {
+			e.HostedZoneID = ""
+			c.zones = append(c.zones, &route53.HostedZone{Id: aws.String("/hostedzone/OTHER"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}})
+			err := updateRoute53(&e)
+
+			Convey("It should error without making any changes", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 zone test matches multiple hosted zones, a hosted zone id is required: /hostedzone/TEST, /hostedzone/OTHER")
+				So(len(c.changes), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the event has no records", func() {
+			c.records = testZoneRecords()
+			e.Records = nil
+
+			Convey("And does not confirm it", func() {
+				err := updateRoute53(&e)
+
+				Convey("It should refuse to empty the zone", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 update of zone test has no records and would remove all 1 of its records, set confirm_empty to allow it")
+					So(len(c.changes), ShouldEqual, 0)
+				})
+			})
+
+			Convey("And confirms it", func() {
+				e.ConfirmEmpty = true
+				err := updateRoute53(&e)
+
+				Convey("It should remove the user records", func() {
+					So(err, ShouldBeNil)
+					So(len(c.changes), ShouldEqual, 1)
+					So(*c.changes[0].ChangeBatch.Changes[0].Action, ShouldEqual, "DELETE")
+					So(*c.changes[0].ChangeBatch.Changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+				})
+			})
+		})
+
+		Convey("When the zone is private", func() {
+			c.zones[0].Config.PrivateZone = aws.Bool(true)
+			c.vpcs = map[string][]*route53.VPC{
+				"/hostedzone/TEST": {
+					{VPCId: aws.String("vpc-00000000"), VPCRegion: aws.String("eu-west-1")},
+					{VPCId: aws.String("vpc-11111111"), VPCRegion: aws.String("eu-west-1")},
+				},
+			}
+			e.Private = true
+
+			Convey("And the event has the vpcs the zone is associated with", func() {
+				e.VPCs = []VPC{{VPCID: "vpc-11111111"}}
+				err := updateRoute53(&e)
+
+				Convey("It should not change any associations", func() {
+					So(err, ShouldBeNil)
+					So(len(c.associated), ShouldEqual, 0)
+					So(len(c.disassociated), ShouldEqual, 0)
+				})
+			})
+
+			Convey("And the event replaces one of the vpcs", func() {
+				e.VPCs = []VPC{{VPCID: "vpc-22222222"}}
+				err := updateRoute53(&e)
+
+				Convey("It should associate the new vpc and disassociate the removed one", func() {
+					So(err, ShouldBeNil)
+					So(len(c.associated), ShouldEqual, 1)
+					So(*c.associated[0].VPC.VPCId, ShouldEqual, "vpc-22222222")
+					So(len(c.disassociated), ShouldEqual, 1)
+					So(*c.disassociated[0].VPC.VPCId, ShouldEqual, "vpc-11111111")
+				})
+			})
+		})
+
+		Convey("When shifting the weight of a single record", func() {
+			blue, green := int64(10), int64(90)
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("blue"), Weight: &blue, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("green"), Weight: &green, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}}},
+			}
+
+			shifted := int64(90)
+			e.Targeted = true
+			e.Records = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 60, SetIdentifier: "blue", Weight: &shifted},
+			}
+			err := updateRoute53(&e)
+
+			Convey("It should only upsert that record set", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 1)
+				So(len(c.changes[0].ChangeBatch.Changes), ShouldEqual, 1)
+
+				change := c.changes[0].ChangeBatch.Changes[0]
+				So(*change.Action, ShouldEqual, "UPSERT")
+				So(*change.ResourceRecordSet.SetIdentifier, ShouldEqual, "blue")
+				So(*change.ResourceRecordSet.Weight, ShouldEqual, 90)
+			})
+		})
+
+		Convey("When deleting a single record set of a weighted set", func() {
+			blue, green := int64(10), int64(90)
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("blue"), Weight: &blue, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("green"), Weight: &green, TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}}},
+			}
+
+			e.Targeted = true
+			e.Records = nil
+			e.RecordsToDelete = Records{
+				{Entry: "www.test", Type: "A", Values: []string{"10.0.0.9"}, TTL: 300, SetIdentifier: "green"},
+			}
+			err := updateRoute53(&e)
+
+			Convey("It should delete the stored record set", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 1)
+				So(len(c.changes[0].ChangeBatch.Changes), ShouldEqual, 1)
+
+				change := c.changes[0].ChangeBatch.Changes[0]
+				So(*change.Action, ShouldEqual, "DELETE")
+				So(change.ResourceRecordSet, ShouldEqual, c.records[1])
+			})
+		})
+
+		Convey("When the zone holds plain and alias record sets", func() {
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.test.")}, {Value: aws.String("ns-2.test.")}}},
+				{Name: aws.String("a.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), AliasTarget: &route53.AliasTarget{HostedZoneId: aws.String("Z32O12XQLNTSW2"), DNSName: aws.String("web.elb.amazonaws.com.")}},
+			}
+			err := updateRoute53(&e)
+
+			Convey("It should report the size of the zone", func() {
+				So(err, ShouldBeNil)
+				So(e.RecordSetCount, ShouldEqual, 3)
+				So(e.ResourceRecordCount, ShouldEqual, 4)
+			})
+		})
+
+		Convey("When waiting for the changes to sync", func() {
+			e.WaitForSync = true
+			err := updateRoute53(&e)
+
+			Convey("It should wait for each batch", func() {
+				So(err, ShouldBeNil)
+				So(c.polls, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When a batch fails with debugging enabled", func() {
+			pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
+			errored := make(chan *nats.Msg, 1)
+			pub.ChanSubscribe("route53.update.aws.error", errored)
+
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("a.test."), Type: aws.String("A"), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.9")}}},
+			}
+			c.failChange = 1
+			e.Debug = true
+			data, _ := json.Marshal(e)
+
+			ev := Event{publisher: pub}
+			ev.Process("route53.update.aws", data)
+			ev.Error(updateRoute53(&ev))
+
+			Convey("It should include the existing records on the error event", func() {
+				msg, timeout := waitMsg(errored)
+				So(timeout, ShouldBeNil)
+
+				var failed Event
+				json.Unmarshal(msg.Data, &failed)
+				So(failed.ErrorMessage, ShouldEqual, "change failed")
+				So(failed.ExistingRecords, ShouldResemble, Records{
+					{Entry: "a.test", Type: "A", Values: []string{"10.0.0.9"}, TTL: 60},
+				})
+			})
+		})
+
+		Convey("When a batch fails without debugging enabled", func() {
+			c.failChange = 1
+			updateRoute53(&e)
+
+			Convey("It should not include the existing records", func() {
+				So(e.ExistingRecords, ShouldBeNil)
+			})
+		})
+
+		Convey("When the second batch fails", func() {
+			c.failChange = 2
+			err := updateRoute53(&e)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "change failed")
+			})
+
+			Convey("It should stop applying batches", func() {
+				So(len(c.changes), ShouldEqual, 2)
+			})
+
+			Convey("It should report the partially applied batches", func() {
+				So(e.AppliedBatches, ShouldEqual, 1)
+				So(e.FailedBatch, ShouldEqual, 2)
+
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldContainSubstring, `"applied_batches":1,"failed_batch":2`)
+			})
+		})
+
+		Convey("When the event changes the zone comment", func() {
+			c.zones[0].Config.Comment = aws.String("old comment")
+			e.Comment = "new comment"
+			err := updateRoute53(&e)
+
+			Convey("It should update the comment", func() {
+				So(err, ShouldBeNil)
+				So(len(c.comments), ShouldEqual, 1)
+				So(*c.comments[0].Id, ShouldEqual, "/hostedzone/TEST")
+				So(*c.comments[0].Comment, ShouldEqual, "new comment")
+			})
+		})
+
+		Convey("When the event keeps the zone comment", func() {
+			c.zones[0].Config.Comment = aws.String("same comment")
+			e.Comment = "same comment"
+			err := updateRoute53(&e)
+
+			Convey("It should not update the comment", func() {
+				So(err, ShouldBeNil)
+				So(len(c.comments), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the event changes the zone visibility", func() {
+			e.Private = true
+			err := updateRoute53(&e)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 zone test is public and can not be made private, the zone must be recreated")
+			})
+
+			Convey("It should not change any records or the comment", func() {
+				So(len(c.changes), ShouldEqual, 0)
+				So(len(c.comments), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestValidateRoute53(t *testing.T) {
+	Convey("Given an event with several problems", t, func() {
+		pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
+		errored := make(chan *nats.Msg, 1)
+		pub.ChanSubscribe("route53.validate.aws.error", errored)
+
+		invalid := testEvent
+		invalid.DatacenterToken = ""
+		invalid.Records = Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.256"}, TTL: 300},
+			{Entry: "test", Type: "MX", Values: []string{"70000 mail.test"}, TTL: 300},
+		}
+		data, _ := json.Marshal(invalid)
+
+		Convey("When validating the event", func() {
+			log.SetOutput(ioutil.Discard)
+			e := Event{publisher: pub}
+			e.Process("route53.validate.aws", data)
+			e.Error(validateRoute53(&e))
+			log.SetOutput(os.Stdout)
+
+			Convey("It should report every problem on the error event", func() {
+				msg, timeout := waitMsg(errored)
+				So(timeout, ShouldBeNil)
+
+				var failed Event
+				json.Unmarshal(msg.Data, &failed)
+				So(failed.ValidationErrors, ShouldResemble, []string{
+					"Datacenter credentials invalid",
+					"Record www.test has an invalid A value '10.0.0.256', must be an ipv4 address",
+					"Record test has an invalid MX priority '70000', must be between 0 and 65535",
+				})
+				So(failed.ErrorMessage, ShouldStartWith, "Route53 event has 3 validation errors: ")
+			})
+		})
+	})
+
+	Convey("Given a valid event", t, func() {
+		data, _ := json.Marshal(testEvent)
+
+		Convey("When validating the event", func() {
+			e := Event{}
+			e.Process("route53.validate.aws", data)
+			err := validateRoute53(&e)
+
+			Convey("It should not error", func() {
+				So(err, ShouldBeNil)
+				So(e.ValidationErrors, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestApplyZones(t *testing.T) {
+	Convey("Given an event with two zones", t, func() {
+		e := testEvent
+		e.Name = ""
+		e.Records = nil
+		e.Zones = []Zone{
+			{HostedZoneID: "/hostedzone/APEX", Name: "test", Records: Records{
+				{Entry: "test", Type: "MX", Values: []string{"10 mail.test"}, TTL: 300},
+			}},
+			{HostedZoneID: "/hostedzone/REGIONAL", Name: "eu.test", Records: Records{
+				{Entry: "www.eu.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}},
+		}
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/APEX"), Name: aws.String("test.")},
+				{Id: aws.String("/hostedzone/REGIONAL"), Name: aws.String("eu.test.")},
+			},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When updating the zones", func() {
+			err := applyZones(&e, updateRoute53)
+
+			Convey("It should apply the records of each zone", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 2)
+				So(*c.changes[0].HostedZoneId, ShouldEqual, "/hostedzone/APEX")
+				So(*c.changes[0].ChangeBatch.Changes[0].ResourceRecordSet.Name, ShouldEqual, "test.")
+				So(*c.changes[1].HostedZoneId, ShouldEqual, "/hostedzone/REGIONAL")
+				So(*c.changes[1].ChangeBatch.Changes[0].ResourceRecordSet.Name, ShouldEqual, "www.eu.test.")
+			})
+		})
+
+		Convey("When updating one of the zones fails", func() {
+			c.failChange = 1
+			err := applyZones(&e, updateRoute53)
+
+			Convey("It should still apply the other zones", func() {
+				So(len(c.changes), ShouldEqual, 2)
+			})
+
+			Convey("It should report the failed zone", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 zones failed: test")
+				So(e.Zones[0].ErrorMessage, ShouldEqual, "change failed")
+				So(e.Zones[1].ErrorMessage, ShouldEqual, "")
+			})
+		})
+
+		Convey("When creating the zones", func() {
+			e.CallerReference = "service-1234"
+			e.Zones[0].HostedZoneID = ""
+			e.Zones[1].HostedZoneID = ""
+			err := applyZones(&e, createRoute53)
+
+			Convey("It should create each zone with its own caller reference", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 2)
+				So(*c.created[0].CallerReference, ShouldEqual, "service-1234-test")
+				So(*c.created[1].CallerReference, ShouldEqual, "service-1234-eu.test")
+				So(e.Zones[0].HostedZoneID, ShouldEqual, "/hostedzone/CREATED")
+			})
+		})
+	})
+}
+
+func TestDeleteRoute53(t *testing.T) {
+	Convey("Given an event deleting a zone", t, func() {
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When the zone exists", func() {
+			err := deleteRoute53(&e)
+
+			Convey("It should delete the zone", func() {
+				So(err, ShouldBeNil)
+				So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+				So(e.AlreadyDeleted, ShouldBeFalse)
+			})
+		})
+
+		Convey("When clearing the records is still pending", func() {
+			var intervals []time.Duration
+			sleep = func(d time.Duration) {
+				intervals = append(intervals, d)
+			}
+			Reset(func() {
+				sleep = time.Sleep
+			})
+
+			c.pending = 2
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			err := deleteRoute53(&e)
+
+			Convey("It should wait for the change to sync before deleting the zone", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 1)
+				So(c.polls, ShouldEqual, 3)
+				So(len(intervals), ShouldEqual, 2)
+				So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+			})
+		})
+
+		Convey("With an allow-list of zones", func() {
+			allowedZones = parseAllowedZones(".test")
+			Reset(func() {
+				allowedZones = nil
+			})
+			c.zones = append(c.zones, &route53.HostedZone{Id: aws.String("/hostedzone/FOREIGN"), Name: aws.String("example.com."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}})
+
+			Convey("When deleting an allowed zone by its id", func() {
+				err := deleteRoute53(&e)
+
+				Convey("It should delete the zone", func() {
+					So(err, ShouldBeNil)
+					So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+				})
+			})
+
+			Convey("When the id of an allowed name belongs to another zone", func() {
+				e.HostedZoneID = "/hostedzone/FOREIGN"
+				err := deleteRoute53(&e)
+
+				Convey("It should refuse to touch the zone", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 hosted zone /hostedzone/FOREIGN is zone example.com, which is not in the zones the connector may manage")
+					So(len(c.changes), ShouldEqual, 0)
+					So(c.deleted, ShouldBeEmpty)
+				})
+			})
+
+			Convey("When reading a zone outside the allow-list by its id alone", func() {
+				e.Name = ""
+				e.HostedZoneID = "/hostedzone/FOREIGN"
+				err := getZoneStatus(&e)
+
+				Convey("It should refuse to read the zone", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "not in the zones the connector may manage")
+					So(c.listed, ShouldEqual, 0)
+				})
+			})
+
+			Convey("When upserting into a zone named differently from the event", func() {
+				c.zones = append(c.zones, &route53.HostedZone{Id: aws.String("/hostedzone/OTHER"), Name: aws.String("other.test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}})
+				e.HostedZoneID = "/hostedzone/OTHER"
+				err := upsertRoute53(&e)
+
+				Convey("It should refuse to touch the zone", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, "Route53 hosted zone /hostedzone/OTHER is zone other.test, not test")
+					So(len(c.changes), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When the event manages the default records", func() {
+			e.ManageDefaultRecords = true
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-00.com.")}}},
+				{Name: aws.String("test."), Type: aws.String("SOA"), TTL: aws.Int64(900), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-00.com. awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			err := deleteRoute53(&e)
+
+			Convey("It should leave the apex NS and SOA records to the zone delete", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 1)
+				changes := c.changes[0].ChangeBatch.Changes
+				So(len(changes), ShouldEqual, 1)
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+				So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+			})
+		})
+
+		Convey("When its records reference health checks the event does not own", func() {
+			c.healthChecks = []string{"11111111-1111-1111-1111-111111111111"}
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("primary"), Failover: aws.String("PRIMARY"), TTL: aws.Int64(300), HealthCheckId: aws.String("11111111-1111-1111-1111-111111111111"), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			err := deleteRoute53(&e)
+
+			Convey("It should leave them", func() {
+				So(err, ShouldBeNil)
+				So(c.deletedHealthChecks, ShouldBeEmpty)
+				So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+			})
+		})
+
+		Convey("When the event owns health checks", func() {
+			e.OwnedHealthChecks = []string{"11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"}
+			c.healthChecks = []string{"11111111-1111-1111-1111-111111111111", "33333333-3333-3333-3333-333333333333"}
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("primary"), Failover: aws.String("PRIMARY"), TTL: aws.Int64(300), HealthCheckId: aws.String("11111111-1111-1111-1111-111111111111"), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			err := deleteRoute53(&e)
+
+			Convey("It should delete them with the zone, skipping those already gone", func() {
+				So(err, ShouldBeNil)
+				So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+				So(c.deletedHealthChecks, ShouldResemble, []string{"11111111-1111-1111-1111-111111111111"})
+				So(c.healthChecks, ShouldResemble, []string{"33333333-3333-3333-3333-333333333333"})
+			})
+		})
+
+		Convey("When deleting a zone with owned health checks fails", func() {
+			e.OwnedHealthChecks = []string{"11111111-1111-1111-1111-111111111111"}
+			c.deleteErr = awserr.New("Throttling", "Rate exceeded", nil)
+			c.healthChecks = []string{"11111111-1111-1111-1111-111111111111"}
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), SetIdentifier: aws.String("primary"), Failover: aws.String("PRIMARY"), TTL: aws.Int64(300), HealthCheckId: aws.String("11111111-1111-1111-1111-111111111111"), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			err := deleteRoute53(&e)
+
+			Convey("It should keep the health checks the removed records point at", func() {
+				So(err, ShouldNotBeNil)
+				So(c.deletedHealthChecks, ShouldBeEmpty)
+				So(e.RemovedRecords[0].HealthCheckID, ShouldEqual, "11111111-1111-1111-1111-111111111111")
+			})
+
+			Convey("And the delete is retried once the zone is gone", func() {
+				c.zones = []*route53.HostedZone{}
+				retry := testEvent
+				retry.HostedZoneID = "/hostedzone/TEST"
+				retry.OwnedHealthChecks = []string{"11111111-1111-1111-1111-111111111111"}
+				err := deleteRoute53(&retry)
+
+				Convey("It should still delete the health checks", func() {
+					So(err, ShouldBeNil)
+					So(retry.AlreadyDeleted, ShouldBeTrue)
+					So(c.deletedHealthChecks, ShouldResemble, []string{"11111111-1111-1111-1111-111111111111"})
+				})
+			})
+		})
+
+		Convey("When deleting the zone fails after its records were removed", func() {
+			c.deleteErr = awserr.New("Throttling", "Rate exceeded", nil)
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.awsdns-00.com.")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			err := deleteRoute53(&e)
+
+			Convey("It should report the removed records and that the zone still exists", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Route53 zone test was not deleted but its 1 records were already removed, the zone still exists")
+				So(err.Error(), ShouldContainSubstring, "Rate exceeded")
+				So(len(c.changes), ShouldEqual, 1)
+				So(len(c.deleted), ShouldEqual, 0)
+				So(e.RemovedRecords, ShouldResemble, Records{
+					{Entry: "www.test", Type: "A", TTL: 300, Values: []string{"10.0.0.1"}},
+				})
+			})
+		})
+
+		Convey("When deleting an empty zone fails", func() {
+			c.deleteErr = awserr.New("Throttling", "Rate exceeded", nil)
+			err := deleteRoute53(&e)
+
+			Convey("It should return the error as is", func() {
+				So(err, ShouldEqual, c.deleteErr)
+				So(e.RemovedRecords, ShouldBeNil)
+			})
+		})
+
+		Convey("When the zone is already gone", func() {
+			pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
+			done := make(chan *nats.Msg, 1)
+			pub.ChanSubscribe("route53.delete.aws.done", done)
+
+			data, _ := json.Marshal(e)
+			ev := Event{publisher: pub}
+			ev.Process("route53.delete.aws", data)
+			c.zones = []*route53.HostedZone{}
+
+			err := deleteRoute53(&ev)
+			ev.Complete()
+
+			Convey("It should complete with the zone flagged as already deleted", func() {
+				So(err, ShouldBeNil)
+				So(len(c.deleted), ShouldEqual, 0)
+
+				msg, timeout := waitMsg(done)
+				So(timeout, ShouldBeNil)
+
+				var completed Event
+				json.Unmarshal(msg.Data, &completed)
+				So(completed.AlreadyDeleted, ShouldBeTrue)
+			})
+		})
+
+		Convey("When no zone has the event's name", func() {
+			e.HostedZoneID = ""
+			c.zones = []*route53.HostedZone{}
+			err := deleteRoute53(&e)
+
+			Convey("It should flag the zone as already deleted", func() {
+				So(err, ShouldBeNil)
+				So(e.AlreadyDeleted, ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestRunEvent(t *testing.T) {
+	Convey("Given an update event", t, func() {
+		pub := &testPublisher{subscriptions: make(map[string]chan *nats.Msg)}
+		done := make(chan *nats.Msg, 1)
+		pub.ChanSubscribe("route53.update.aws.done", done)
+
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+		e.Records = Records{
+			{Entry: "a.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			{Entry: "b.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+		}
+		data, _ := json.Marshal(e)
+
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			},
+			records: []*route53.ResourceRecordSet{
+				{Name: aws.String("old.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}}},
+			},
+		}
+		testClient(c)
+
+		start := time.Now()
+		var calls int
+		now = func() time.Time {
+			calls++
+			return start.Add(time.Duration(calls*25) * time.Millisecond)
+		}
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+			now = time.Now
+		})
+
+		Convey("When the event completes", func() {
+			ev := Event{publisher: pub}
+			ev.Process("route53.update.aws", data)
+			err := runEvent(&ev, updateRoute53)
+			ev.Complete()
+
+			Convey("It should report its duration and the changes applied", func() {
+				So(err, ShouldBeNil)
+
+				msg, timeout := waitMsg(done)
+				So(timeout, ShouldBeNil)
+
+				var completed Event
+				json.Unmarshal(msg.Data, &completed)
+				So(completed.DurationMs, ShouldBeGreaterThan, 0)
+				So(completed.ChangeCount, ShouldEqual, 3)
+			})
+		})
+	})
+}
+
+func TestEventResult(t *testing.T) {
+	Convey("Given a zone managed by the connector", t, func() {
+		e := testEvent
+		e.Records = Records{
+			{Entry: "a.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			{Entry: "b.test", Type: "A", Values: []string{"10.0.0.2", "10.0.0.3"}, TTL: 300},
+		}
+
+		c := &testRoute53Client{
+			delegationSet: &route53.DelegationSet{NameServers: aws.StringSlice([]string{"ns-1.awsdns-01.org", "ns-2.awsdns-02.com"})},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When creating the zone", func() {
+			err := runEvent(&e, createRoute53)
+
+			Convey("It should report the zone, its nameservers and the changes made", func() {
+				So(err, ShouldBeNil)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/CREATED")
+				So(e.NameServers, ShouldResemble, []string{"ns-1.awsdns-01.org", "ns-2.awsdns-02.com"})
+				So(e.ChangeIDs, ShouldResemble, []string{"/change/TEST"})
+				So(e.ChangeCount, ShouldEqual, 2)
+				So(e.AppliedBatches, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When updating the zone", func() {
+			c.zones = []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			}
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("a.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("b.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}, {Value: aws.String("10.0.0.3")}}},
+			}
+			e.HostedZoneID = "/hostedzone/TEST"
+			e.NameServers = []string{"ns-stale.test"}
+			e.Records[1].Values = []string{"10.0.0.2"}
+			err := runEvent(&e, updateRoute53)
+
+			Convey("It should report the changes made and the size of the zone", func() {
+				So(err, ShouldBeNil)
+				So(e.ChangeIDs, ShouldResemble, []string{"/change/TEST"})
+				So(e.ChangeCount, ShouldEqual, 1)
+				So(e.RecordSetCount, ShouldEqual, 2)
+				So(e.ResourceRecordCount, ShouldEqual, 3)
+			})
+
+			Convey("It should not report results the event was sent with", func() {
+				So(e.NameServers, ShouldBeNil)
+			})
+		})
+
+		Convey("When deleting the zone", func() {
+			c.zones = []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			}
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("a.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+			e.HostedZoneID = "/hostedzone/TEST"
+			err := runEvent(&e, deleteRoute53)
+
+			Convey("It should report the change removing the records", func() {
+				So(err, ShouldBeNil)
+				So(e.ChangeIDs, ShouldResemble, []string{"/change/TEST"})
+				So(e.ChangeCount, ShouldEqual, 1)
+				So(e.AlreadyDeleted, ShouldBeFalse)
+				So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+			})
+		})
+	})
+}
+
+func TestUpsertRoute53(t *testing.T) {
+	Convey("Given an event upserting records", t, func() {
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+		e.RecordsToDelete = Records{
+			{Entry: "old.test", Type: "A", Values: []string{"10.0.0.9"}, TTL: 300},
+		}
+		e.Records = Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.2"}, TTL: 300},
+		}
+
+		c := &testRoute53Client{
+			records: []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("NS"), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-1.test.")}}},
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("api.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.3")}}},
+			},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When upserting the records", func() {
+			err := upsertRoute53(&e)
+
+			Convey("It should only upsert the supplied records", func() {
+				So(err, ShouldBeNil)
+				So(len(c.changes), ShouldEqual, 1)
+				So(len(c.changes[0].ChangeBatch.Changes), ShouldEqual, 1)
+
+				change := c.changes[0].ChangeBatch.Changes[0]
+				So(*change.Action, ShouldEqual, "UPSERT")
+				So(*change.ResourceRecordSet.Name, ShouldEqual, "www.test.")
+				So(*change.ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, "10.0.0.2")
+			})
+		})
+	})
+}
+
+func TestVerifyCredentials(t *testing.T) {
+	Convey("Given an event set to verify its credentials", t, func() {
+		e := testEvent
+		e.VerifyCredentials = true
+
+		c := &testRoute53Client{}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When the credentials are valid", func() {
+			err := verifyCredentials(&e)
+
+			Convey("It should not error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When aws denies access", func() {
+			c.countErr = awserr.New("AccessDenied", "User is not authorized to perform route53:GetHostedZoneCount", nil)
+			err := verifyCredentials(&e)
+
+			Convey("It should report the credentials as invalid", func() {
+				So(err, ShouldEqual, ErrDatacenterCredentialsInvalid)
+			})
+		})
+
+		Convey("When the call fails for another reason", func() {
+			c.countErr = awserr.New("Throttling", "Rate exceeded", nil)
+			err := verifyCredentials(&e)
+
+			Convey("It should return the error", func() {
+				So(err, ShouldEqual, c.countErr)
+			})
+		})
+	})
+}
+
+func TestRoute53ReadClient(t *testing.T) {
+	Convey("Given an event", t, func() {
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+
+		var clients []string
+		getRoute53Client = func(ev *Event) route53iface.Route53API {
+			clients = append(clients, ev.DatacenterSecret)
+			return &testRoute53Client{
+				zones: []*route53.HostedZone{
+					{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test.")},
+				},
+			}
+		}
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("With read credentials", func() {
+			e.ReadDatacenterSecret = "read-key"
+			e.ReadDatacenterToken = "read-token"
+
+			Convey("When updating the zone", func() {
+				err := updateRoute53(&e)
+
+				Convey("It should use separate clients for reads and writes", func() {
+					So(err, ShouldBeNil)
+					So(clients, ShouldResemble, []string{"read-key", "read-key", "key", "read-key"})
+				})
+			})
+		})
+
+		Convey("Without read credentials", func() {
+			Convey("When updating the zone", func() {
+				err := updateRoute53(&e)
+
+				Convey("It should use the primary credentials for reads", func() {
+					So(err, ShouldBeNil)
+					So(clients, ShouldResemble, []string{"key", "key", "key", "key"})
+				})
+			})
+		})
+	})
+}
+
+func TestWaitForChange(t *testing.T) {
+	Convey("Given a change that is pending", t, func() {
+		e := testEvent
+
+		var intervals []time.Duration
+		start := time.Now()
+		sleep = func(d time.Duration) {
+			intervals = append(intervals, d)
+		}
+		now = func() time.Time {
+			var waited time.Duration
+			for _, d := range intervals {
+				waited += d
+			}
+			return start.Add(waited)
+		}
+
+		c := &testRoute53Client{pending: 4}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+			sleep = time.Sleep
+			now = time.Now
+			syncTimeout = 5 * time.Minute
+		})
+
+		Convey("When waiting for the change", func() {
+			err := waitForChange(&e, aws.String("/change/TEST"))
+
+			Convey("It should poll until the change is in sync", func() {
+				So(err, ShouldBeNil)
+				So(c.polls, ShouldEqual, 5)
+				So(len(intervals), ShouldEqual, 4)
+			})
+
+			Convey("It should back off between polls", func() {
+				for i := 1; i < len(intervals); i++ {
+					So(intervals[i], ShouldBeGreaterThan, intervals[i-1])
+				}
+				So(intervals[0], ShouldBeGreaterThanOrEqualTo, time.Second)
+				So(intervals[3], ShouldBeGreaterThanOrEqualTo, 8*time.Second)
+			})
+		})
+
+		Convey("When the change outlasts the timeout", func() {
+			syncTimeout = 3 * time.Second
+			err := waitForChange(&e, aws.String("/change/TEST"))
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrChangeTimeout)
+				So(len(intervals), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a poll interval", t, func() {
+		Convey("When backing off many times", func() {
+			interval := pollInterval(10)
+
+			Convey("It should cap the interval", func() {
+				So(interval, ShouldEqual, 30*time.Second)
+			})
+		})
+	})
+}
+
+func TestRoute53Client(t *testing.T) {
+	Convey("Given an event without a region", t, func() {
+		e := testEvent
+		e.DatacenterRegion = ""
+
+		Convey("When building the route53 client", func() {
+			svc := newRoute53Client(&e).(*route53.Route53)
+
+			Convey("It should use the default region", func() {
+				So(*svc.Client.Config.Region, ShouldEqual, "us-east-1")
+			})
+		})
+
+		Convey("When a default region is configured", func() {
+			os.Setenv("AWS_DEFAULT_REGION", "eu-central-1")
+			defaultRegion = getDefaultRegion()
+			svc := newRoute53Client(&e).(*route53.Route53)
+			os.Unsetenv("AWS_DEFAULT_REGION")
+			defaultRegion = getDefaultRegion()
+
+			Convey("It should use the configured region", func() {
+				So(*svc.Client.Config.Region, ShouldEqual, "eu-central-1")
+			})
+		})
+	})
+
+	Convey("Given an event with a datacenter name", t, func() {
+		e := testEvent
+		e.DatacenterName = "production"
+
+		Convey("When building a request", func() {
+			svc := newRoute53Client(&e).(*route53.Route53)
+			req, _ := svc.GetHostedZoneCountRequest(&route53.GetHostedZoneCountInput{})
+			req.Build()
+
+			Convey("It should name the datacenter in the user agent", func() {
+				So(req.HTTPRequest.Header.Get("User-Agent"), ShouldContainSubstring, "datacenter/production")
+			})
+		})
+	})
+
+	Convey("Given an event with a region", t, func() {
+		e := testEvent
+
+		Convey("When building the route53 client", func() {
+			svc := newRoute53Client(&e).(*route53.Route53)
+
+			Convey("It should use the event region", func() {
+				So(*svc.Client.Config.Region, ShouldEqual, "eu-west-1")
+			})
+		})
+	})
+}
+
+func TestClientConfig(t *testing.T) {
+	Convey("Given an event", t, func() {
+		e := testEvent
+
+		Convey("When building the client config", func() {
+			config := clientConfig(&e, "eu-west-1")
+
+			Convey("It should use the default retries and timeout", func() {
+				So(*config.MaxRetries, ShouldEqual, 3)
+				So(config.HTTPClient.Timeout, ShouldEqual, 30*time.Second)
+			})
+		})
+
+		Convey("When retries and the timeout are configured", func() {
+			maxRetries = 5
+			httpTimeout = 10 * time.Second
+			Reset(func() {
+				maxRetries = 3
+				httpTimeout = 30 * time.Second
+			})
+
+			svc := newRoute53Client(&e).(*route53.Route53)
+
+			Convey("It should build the client with the configured values", func() {
+				So(*svc.Client.Config.MaxRetries, ShouldEqual, 5)
+				So(svc.Client.Config.HTTPClient.Timeout, ShouldEqual, 10*time.Second)
+				So(*svc.Client.Config.Region, ShouldEqual, "eu-west-1")
+			})
+		})
+	})
+}
+
+func TestRenderValues(t *testing.T) {
+	Convey("Given values of each record type", t, func() {
+		tests := []struct {
+			recordType string
+			value      string
+			expected   string
+		}{
+			{"A", "10.0.0.1", "10.0.0.1"},
+			{"AAAA", "2001:db8::1", "2001:db8::1"},
+			{"AAAA", "2001:0DB8:0000:0000:0000:0000:0000:0001", "2001:db8::1"},
+			{"A", " 10.0.0.2 ", "10.0.0.2"},
+			{"CNAME", "www.example.com", "www.example.com"},
+			{"NS", "ns-1.example.com.", "ns-1.example.com."},
+			{"TXT", "v=spf1 -all", `"v=spf1 -all"`},
+			{"TXT", `"already quoted"`, `"already quoted"`},
+			{"SPF", "v=spf1 -all", `"v=spf1 -all"`},
+			{"MX", "10  mail.example.com", "10 mail.example.com"},
+			{"SRV", " 10 5\t5060 sip.example.com ", "10 5 5060 sip.example.com"},
+			{"CAA", "0 issue letsencrypt.org", `0 issue "letsencrypt.org"`},
+			{"CAA", `0 iodef "mailto:admin@example.com"`, `0 iodef "mailto:admin@example.com"`},
+			{"NAPTR", ` 100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" . `, `100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`},
+		}
+
+		for _, test := range tests {
+			Convey("When rendering the "+test.recordType+" value "+test.value, func() {
+				records := renderValues(test.recordType, []string{test.value})
+
+				Convey("It should render it as "+test.expected, func() {
+					So(len(records), ShouldEqual, 1)
+					So(*records[0].Value, ShouldEqual, test.expected)
+				})
+			})
+		}
+	})
+
+	Convey("Given values with duplicates", t, func() {
+		values := []string{"10.0.0.2", "10.0.0.1", "10.0.0.2", " 10.0.0.1 ", "10.0.0.3"}
+
+		Convey("When rendering the values", func() {
+			records := renderValues("A", values)
+
+			Convey("It should keep the first of each value in order", func() {
+				So(len(records), ShouldEqual, 3)
+				So(*records[0].Value, ShouldEqual, "10.0.0.2")
+				So(*records[1].Value, ShouldEqual, "10.0.0.1")
+				So(*records[2].Value, ShouldEqual, "10.0.0.3")
+			})
+		})
+	})
+}
+
+func TestBatchChanges(t *testing.T) {
+	Convey("Given many large txt records", t, func() {
+		e := testEvent
+		e.Records = nil
+		for i := 0; i < 20; i++ {
+			e.Records = append(e.Records, Record{
+				Entry:  fmt.Sprintf("txt%d.test", i),
+				Type:   "TXT",
+				Values: []string{strings.Repeat("a", 1000), strings.Repeat("b", 1000)},
+				TTL:    300,
+			})
+		}
+
+		Convey("When batching the changes", func() {
+			changes := buildChanges(&e, nil)
+			batches := batchChanges(changes)
+
+			Convey("It should split batches on the value size", func() {
+				So(len(changes), ShouldEqual, 20)
+				So(len(batches), ShouldEqual, 3)
+				So(len(batches[0]), ShouldEqual, 7)
+				So(len(batches[1]), ShouldEqual, 7)
+				So(len(batches[2]), ShouldEqual, 6)
+			})
+
+			Convey("It should keep each batch within the limit", func() {
+				for _, batch := range batches {
+					var size int
+					for _, c := range batch {
+						size += changeSize(c)
+					}
+					So(size, ShouldBeLessThanOrEqualTo, maxBatchValueSize)
+				}
+			})
+		})
+
+		Convey("When batching small changes", func() {
+			e.Records = e.Records[:3]
+			for i := range e.Records {
+				e.Records[i].Values = []string{"small"}
+			}
+			batches := batchChanges(buildChanges(&e, nil))
+
+			Convey("It should send them in a single batch", func() {
+				So(len(batches), ShouldEqual, 1)
+				So(len(batches[0]), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When batching record sets replacing existing ones", func() {
+			e.Records = e.Records[:3]
+			var existing []*route53.ResourceRecordSet
+			for i := range e.Records {
+				e.Records[i].Values = []string{"small"}
+				existing = append(existing, &route53.ResourceRecordSet{
+					Name: aws.String(canonicalName(e.Records[i].Entry)), Type: aws.String("TXT"), TTL: aws.Int64(300),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"old"`)}},
+				})
+			}
+			maxBatchChanges = 3
+			Reset(func() {
+				maxBatchChanges = 1000
+			})
+			batches := batchChanges(conditionalChanges(&e, buildChanges(&e, existing), existing))
+
+			Convey("It should keep each delete in the batch of its create", func() {
+				So(len(batches), ShouldEqual, 3)
+				for _, batch := range batches {
+					So(len(batch), ShouldEqual, 2)
+					So(isReplacement(batch[0], batch[1]), ShouldBeTrue)
+				}
+			})
+		})
+	})
+}
+
+func TestEventAction(t *testing.T) {
+	Convey("Given an event", t, func() {
+		e := testEvent
+
+		Convey("When its action is supported", func() {
+			e.resource = "route53"
+			e.action = "update"
+			handler, err := eventAction(&e)
+
+			Convey("It should return its handler", func() {
+				So(err, ShouldBeNil)
+				So(handler, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When its action is not supported", func() {
+			e.resource = "route53"
+			e.action = "foo"
+			handler, err := eventAction(&e)
+
+			Convey("It should error", func() {
+				So(handler, ShouldBeNil)
+				So(err.Error(), ShouldEqual, "Route53 action foo is not supported for route53")
+			})
+		})
+	})
+}
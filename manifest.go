@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// manifestLabel prefixes the zone name to form the name of the txt record
+// listing the records managed by the connector
+const manifestLabel = "_ernest-managed"
+
+func manifestName(zone string) string {
+	return manifestLabel + "." + zoneName(zone)
+}
+
+// manifestKey identifies a record in the manifest, route53 stores names in
+// lower case so they are compared that way
+func manifestKey(name, recordType string) string {
+	return strings.ToLower(entryName(name)) + " " + recordType
+}
+
+func isManifest(ev *Event, record *route53.ResourceRecordSet) bool {
+	return sameName(*record.Name, manifestName(ev.Name)) && *record.Type == "TXT"
+}
+
+func findManifest(ev *Event, existing []*route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	for _, recordSet := range existing {
+		if isManifest(ev, recordSet) {
+			return recordSet
+		}
+	}
+	return nil
+}
+
+// readManifest returns the records the connector previously created in the zone
+func readManifest(ev *Event, existing []*route53.ResourceRecordSet) map[string]bool {
+	managed := make(map[string]bool)
+
+	manifest := findManifest(ev, existing)
+	if manifest == nil {
+		return managed
+	}
+
+	for _, rr := range manifest.ResourceRecords {
+		// keys written before names were lowercased are read the same way
+		parts := strings.SplitN(unquoteTXT(*rr.Value), " ", 2)
+		if len(parts) == 2 {
+			managed[manifestKey(parts[0], parts[1])] = true
+		}
+	}
+
+	return managed
+}
+
+// buildManifest lists the records of the event in a txt record, append only
+// events also keep the records listed by earlier events
+func buildManifest(ev *Event, existing []*route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	var keys []string
+
+	seen := make(map[string]bool)
+	for _, record := range ev.Records {
+		key := manifestKey(record.Entry, record.Type)
+		if seen[key] != true {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if ev.AppendOnly {
+		for key := range readManifest(ev, existing) {
+			if seen[key] != true {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return &route53.ResourceRecordSet{
+		Name:            aws.String(canonicalName(manifestName(ev.Name))),
+		Type:            aws.String("TXT"),
+		TTL:             aws.Int64(defaultTTL),
+		ResourceRecords: renderValues("TXT", keys),
+	}
+}
+
+// buildManifestChange keeps the manifest in line with the records of the event,
+// removing it once the event no longer has any
+func buildManifestChange(ev *Event, existing []*route53.ResourceRecordSet) *route53.Change {
+	manifest := findManifest(ev, existing)
+
+	if len(ev.Records) > 0 {
+		desired := buildManifest(ev, existing)
+
+		// a manifest listing the same records needs no change
+		if manifest != nil && recordSetsEqual(desired, manifest) {
+			return nil
+		}
+
+		return &route53.Change{
+			Action:            aws.String("UPSERT"),
+			ResourceRecordSet: desired,
+		}
+	}
+
+	// append only events never remove what earlier events listed
+	if manifest == nil || ev.AppendOnly {
+		return nil
+	}
+
+	return &route53.Change{
+		Action:            aws.String("DELETE"),
+		ResourceRecordSet: manifest,
+	}
+}
@@ -0,0 +1,165 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestManifest(t *testing.T) {
+	Convey("Given an event tracking record ownership", t, func() {
+		e := testEvent
+		e.OwnershipManifest = true
+		e.Records = Records{
+			{Entry: "www.test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			{Entry: "test", Type: "MX", Values: []string{"10 mail.test"}, TTL: 300},
+		}
+
+		Convey("When building the manifest", func() {
+			manifest := buildManifest(&e, nil)
+
+			Convey("It should list the records of the event", func() {
+				So(*manifest.Name, ShouldEqual, "_ernest-managed.test.")
+				So(*manifest.Type, ShouldEqual, "TXT")
+				So(len(manifest.ResourceRecords), ShouldEqual, 2)
+				So(*manifest.ResourceRecords[0].Value, ShouldEqual, `"test MX"`)
+				So(*manifest.ResourceRecords[1].Value, ShouldEqual, `"www.test A"`)
+			})
+
+			Convey("And reading it back from the zone", func() {
+				managed := readManifest(&e, []*route53.ResourceRecordSet{manifest})
+
+				Convey("It should return the managed records", func() {
+					So(managed, ShouldResemble, map[string]bool{"test MX": true, "www.test A": true})
+				})
+			})
+		})
+
+		Convey("When the zone and records are named in mixed case", func() {
+			e.Name = "Test"
+			e.Records = Records{
+				{Entry: "WWW.Test", Type: "A", Values: []string{"10.0.0.1"}, TTL: 300},
+			}
+			existing := []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A"), TTL: aws.Int64(300), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+				{Name: aws.String("old.test."), Type: aws.String("A")},
+				{
+					Name: aws.String("_ernest-managed.test."),
+					Type: aws.String("TXT"),
+					ResourceRecords: []*route53.ResourceRecord{
+						{Value: aws.String(`"Old.Test A"`)},
+						{Value: aws.String(`"www.test A"`)},
+					},
+				},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should recognise the manifest and its records", func() {
+				So(isManifest(&e, existing[2]), ShouldBeTrue)
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].Action, ShouldEqual, "DELETE")
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "old.test.")
+				So(*changes[1].ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, `"www.test A"`)
+			})
+		})
+
+		Convey("When updating a zone with managed and manual records", func() {
+			existing := []*route53.ResourceRecordSet{
+				{Name: aws.String("test."), Type: aws.String("SOA")},
+				{Name: aws.String("test."), Type: aws.String("NS")},
+				{Name: aws.String("www.test."), Type: aws.String("A")},
+				{Name: aws.String("old.test."), Type: aws.String("A")},
+				{Name: aws.String("manual.test."), Type: aws.String("A")},
+				{
+					Name: aws.String("_ernest-managed.test."),
+					Type: aws.String("TXT"),
+					ResourceRecords: []*route53.ResourceRecord{
+						{Value: aws.String(`"old.test A"`)},
+						{Value: aws.String(`"www.test A"`)},
+					},
+				},
+			}
+			changes := buildChanges(&e, existing)
+
+			Convey("It should only remove records in the manifest", func() {
+				var deleted []string
+				for _, c := range changes {
+					if *c.Action == "DELETE" {
+						deleted = append(deleted, *c.ResourceRecordSet.Name)
+					}
+				}
+				So(deleted, ShouldResemble, []string{"old.test."})
+			})
+
+			Convey("It should skip the manual records", func() {
+				So(e.SkippedRecords, ShouldContain, "manual.test.")
+			})
+
+			Convey("It should update the manifest", func() {
+				last := changes[len(changes)-1]
+				So(*last.Action, ShouldEqual, "UPSERT")
+				So(*last.ResourceRecordSet.Name, ShouldEqual, "_ernest-managed.test.")
+				So(len(last.ResourceRecordSet.ResourceRecords), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When removing all records from the zone", func() {
+			manifest := &route53.ResourceRecordSet{
+				Name:            aws.String("_ernest-managed.test."),
+				Type:            aws.String("TXT"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"www.test A"`)}},
+			}
+			e.Records = nil
+			changes := buildChanges(&e, []*route53.ResourceRecordSet{
+				{Name: aws.String("www.test."), Type: aws.String("A")},
+				manifest,
+			})
+
+			Convey("It should remove the managed records and the manifest", func() {
+				So(len(changes), ShouldEqual, 2)
+				So(*changes[0].ResourceRecordSet.Name, ShouldEqual, "www.test.")
+				So(*changes[1].Action, ShouldEqual, "DELETE")
+				So(changes[1].ResourceRecordSet, ShouldEqual, manifest)
+			})
+		})
+
+		Convey("When the manifest already lists the records of the event", func() {
+			manifest := buildManifest(&e, nil)
+			change := buildManifestChange(&e, []*route53.ResourceRecordSet{manifest})
+
+			Convey("It should not update the manifest", func() {
+				So(change, ShouldBeNil)
+			})
+		})
+
+		Convey("When an append only event updates the manifest", func() {
+			e.AppendOnly = true
+			manifest := &route53.ResourceRecordSet{
+				Name:            aws.String("_ernest-managed.test."),
+				Type:            aws.String("TXT"),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(`"old.test A"`)}},
+			}
+			change := buildManifestChange(&e, []*route53.ResourceRecordSet{manifest})
+
+			Convey("It should keep the records listed earlier", func() {
+				So(*change.Action, ShouldEqual, "UPSERT")
+				So(len(change.ResourceRecordSet.ResourceRecords), ShouldEqual, 3)
+				So(*change.ResourceRecordSet.ResourceRecords[0].Value, ShouldEqual, `"old.test A"`)
+			})
+
+			Convey("And the event has no records", func() {
+				e.Records = nil
+
+				Convey("It should keep the manifest", func() {
+					So(buildManifestChange(&e, []*route53.ResourceRecordSet{manifest}), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// partition stores how route53 is reached in an aws partition
+type partition struct {
+	// regionPrefix is the start of the name of every region of the partition
+	regionPrefix string
+	// endpoint and region are where route53 is served and signed for, as a
+	// global service it has a single endpoint per partition
+	endpoint string
+	region   string
+}
+
+// partitions are the aws partitions route53 is available in, the standard
+// partition's endpoint is left to the sdk
+var partitions = map[string]partition{
+	"aws":        {},
+	"aws-us-gov": {regionPrefix: "us-gov-", endpoint: "https://route53.us-gov.amazonaws.com", region: "us-gov-west-1"},
+	"aws-cn":     {regionPrefix: "cn-", endpoint: "https://route53.amazonaws.com.cn", region: "cn-northwest-1"},
+}
+
+// regionPartition returns the partition a region belongs to
+func regionPartition(region string) string {
+	for name, p := range partitions {
+		if p.regionPrefix != "" && strings.HasPrefix(region, p.regionPrefix) {
+			return name
+		}
+	}
+	return "aws"
+}
+
+// partition returns the event's partition, detected from its region when
+// not given
+func (ev *Event) partition() string {
+	if ev.Partition != "" {
+		return ev.Partition
+	}
+	return regionPartition(ev.DatacenterRegion)
+}
+
+// validatePartition checks the event's partition is known and its region
+// belongs to it
+func (ev *Event) validatePartition() error {
+	if _, ok := partitions[ev.Partition]; !ok {
+		return fmt.Errorf("Route53 partition %s is not supported", ev.Partition)
+	}
+
+	if ev.DatacenterRegion != "" && regionPartition(ev.DatacenterRegion) != ev.Partition {
+		return fmt.Errorf("Route53 region %s is not in the %s partition", ev.DatacenterRegion, ev.Partition)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartition(t *testing.T) {
+	Convey("Given regions of each partition", t, func() {
+		tests := map[string]string{
+			"eu-west-1":      "aws",
+			"us-east-1":      "aws",
+			"us-gov-west-1":  "aws-us-gov",
+			"us-gov-east-1":  "aws-us-gov",
+			"cn-north-1":     "aws-cn",
+			"cn-northwest-1": "aws-cn",
+		}
+
+		for region, expected := range tests {
+			Convey("When resolving the partition of "+region, func() {
+				Convey("It should be "+expected, func() {
+					So(regionPartition(region), ShouldEqual, expected)
+				})
+			})
+		}
+	})
+
+	Convey("Given an event in a govcloud region", t, func() {
+		e := testEvent
+		e.DatacenterRegion = "us-gov-east-1"
+
+		Convey("When building the route53 client", func() {
+			svc := newRoute53Client(&e).(*route53.Route53)
+
+			Convey("It should use the govcloud endpoint and signing region", func() {
+				So(e.partition(), ShouldEqual, "aws-us-gov")
+				So(svc.Client.Endpoint, ShouldEqual, "https://route53.us-gov.amazonaws.com")
+				So(*svc.Client.Config.Region, ShouldEqual, "us-gov-west-1")
+			})
+		})
+
+		Convey("When the event declares the govcloud partition", func() {
+			e.Partition = "aws-us-gov"
+			err := e.Validate()
+
+			Convey("It should not error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the event declares the standard partition", func() {
+			e.Partition = "aws"
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 region us-gov-east-1 is not in the aws partition")
+			})
+		})
+
+		Convey("When the event declares an unknown partition", func() {
+			e.Partition = "aws-iso"
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 partition aws-iso is not supported")
+			})
+		})
+	})
+}
@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53resolver"
+	"github.com/aws/aws-sdk-go/service/route53resolver/route53resolveriface"
+)
+
+func createResolverRuleAssociation(ev *Event) error {
+	svc := getResolverClient(ev)
+
+	req := &route53resolver.AssociateResolverRuleInput{
+		ResolverRuleId: aws.String(ev.ResolverRuleID),
+		VPCId:          aws.String(ev.VPCID),
+	}
+
+	resp, err := svc.AssociateResolverRule(req)
+	if err != nil {
+		return err
+	}
+
+	ev.ResolverAssociationID = aws.StringValue(resp.ResolverRuleAssociation.Id)
+
+	return nil
+}
+
+func deleteResolverRuleAssociation(ev *Event) error {
+	svc := getResolverClient(ev)
+
+	req := &route53resolver.DisassociateResolverRuleInput{
+		ResolverRuleId: aws.String(ev.ResolverRuleID),
+		VPCId:          aws.String(ev.VPCID),
+	}
+
+	_, err := svc.DisassociateResolverRule(req)
+
+	return err
+}
+
+// getResolverClient returns the client used for an event's resolver calls
+var getResolverClient = newResolverClient
+
+func newResolverClient(ev *Event) route53resolveriface.Route53ResolverAPI {
+	return route53resolver.New(newSession(ev), clientConfig(ev, ev.DatacenterRegion))
+}
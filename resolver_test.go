@@ -0,0 +1,126 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53resolver"
+	"github.com/aws/aws-sdk-go/service/route53resolver/route53resolveriface"
+	"github.com/nats-io/nats"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testResolverClient struct {
+	route53resolveriface.Route53ResolverAPI
+	associated    []*route53resolver.AssociateResolverRuleInput
+	disassociated []*route53resolver.DisassociateResolverRuleInput
+}
+
+func (c *testResolverClient) AssociateResolverRule(in *route53resolver.AssociateResolverRuleInput) (*route53resolver.AssociateResolverRuleOutput, error) {
+	c.associated = append(c.associated, in)
+	return &route53resolver.AssociateResolverRuleOutput{
+		ResolverRuleAssociation: &route53resolver.ResolverRuleAssociation{
+			Id:             aws.String("rslvr-rrassoc-97242eaf88example"),
+			ResolverRuleId: in.ResolverRuleId,
+			VPCId:          in.VPCId,
+			Status:         aws.String(route53resolver.ResolverRuleAssociationStatusCreating),
+		},
+	}, nil
+}
+
+func (c *testResolverClient) DisassociateResolverRule(in *route53resolver.DisassociateResolverRuleInput) (*route53resolver.DisassociateResolverRuleOutput, error) {
+	c.disassociated = append(c.disassociated, in)
+	return &route53resolver.DisassociateResolverRuleOutput{}, nil
+}
+
+func TestResolverRuleAssociation(t *testing.T) {
+	pub, _, _ := testSetup()
+
+	Convey("Given a resolver rule association event", t, func() {
+		e := Event{
+			UUID:             "test",
+			VPCID:            "vpc-00000000",
+			ResolverRuleID:   "rslvr-rr-5328a0899example",
+			DatacenterRegion: "eu-west-1",
+			DatacenterSecret: "key",
+			DatacenterToken:  "token",
+		}
+
+		c := &testResolverClient{}
+		getResolverClient = func(ev *Event) route53resolveriface.Route53ResolverAPI {
+			return c
+		}
+		Reset(func() {
+			getResolverClient = newResolverClient
+		})
+
+		Convey("When validating the event", func() {
+			data, _ := json.Marshal(e)
+			ev := Event{publisher: pub}
+			ev.Process("route53_resolver.create.aws", data)
+			err := ev.Validate()
+
+			Convey("It should not require a zone", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When validating the event without a rule id", func() {
+			e.ResolverRuleID = ""
+			data, _ := json.Marshal(e)
+			ev := Event{publisher: pub}
+			ev.Process("route53_resolver.create.aws", data)
+			err := ev.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrResolverRuleIDInvalid)
+			})
+		})
+
+		Convey("When associating the rule", func() {
+			err := createResolverRuleAssociation(&e)
+
+			Convey("It should associate the rule with the vpc", func() {
+				So(err, ShouldBeNil)
+				So(len(c.associated), ShouldEqual, 1)
+				So(*c.associated[0].ResolverRuleId, ShouldEqual, "rslvr-rr-5328a0899example")
+				So(*c.associated[0].VPCId, ShouldEqual, "vpc-00000000")
+			})
+
+			Convey("It should store the association id", func() {
+				So(e.ResolverAssociationID, ShouldEqual, "rslvr-rrassoc-97242eaf88example")
+			})
+		})
+
+		Convey("When completing the association", func() {
+			done := make(chan *nats.Msg, 10)
+			pub.ChanSubscribe("route53_resolver.create.aws.done", done)
+
+			data, _ := json.Marshal(e)
+			ev := Event{publisher: pub}
+			ev.Process("route53_resolver.create.aws", data)
+			ev.Complete()
+
+			Convey("It should publish a route53_resolver.create.aws.done event", func() {
+				msg, timeout := waitMsg(done)
+				So(timeout, ShouldBeNil)
+				So(msg.Subject, ShouldEqual, "route53_resolver.create.aws.done")
+			})
+		})
+
+		Convey("When disassociating the rule", func() {
+			err := deleteResolverRuleAssociation(&e)
+
+			Convey("It should disassociate the rule from the vpc", func() {
+				So(err, ShouldBeNil)
+				So(len(c.disassociated), ShouldEqual, 1)
+				So(*c.disassociated[0].VPCId, ShouldEqual, "vpc-00000000")
+			})
+		})
+	})
+}
@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// ErrRetryBudgetExceeded : error for an event that used up its retry budget
+var ErrRetryBudgetExceeded = errors.New("Route53 retry budget exceeded")
+
+// maxEventRetries is the most retries made across all aws calls of an event
+var maxEventRetries = 20
+
+// retryBudgetTimeout is the longest an event keeps retrying failed aws calls
+var retryBudgetTimeout = 2 * time.Minute
+
+// maxRetryDelay caps the backoff between retries of an aws call
+var maxRetryDelay = 20 * time.Second
+
+// retryableCodes are the aws error codes of transient failures
+var retryableCodes = map[string]bool{
+	"Throttling":                           true,
+	"ThrottlingException":                  true,
+	"RequestLimitExceeded":                 true,
+	"InternalError":                        true,
+	"InternalFailure":                      true,
+	"ServiceUnavailable":                   true,
+	"RequestTimeout":                       true,
+	route53.ErrCodePriorRequestNotComplete: true,
+}
+
+// permanentCodes are the aws error codes of failures that retrying won't fix
+var permanentCodes = map[string]bool{
+	"AccessDenied":                         true,
+	"InvalidClientTokenId":                 true,
+	"SignatureDoesNotMatch":                true,
+	route53.ErrCodeInvalidInput:            true,
+	route53.ErrCodeInvalidChangeBatch:      true,
+	route53.ErrCodeInvalidDomainName:       true,
+	route53.ErrCodeInvalidVPCId:            true,
+	route53.ErrCodeNoSuchHostedZone:        true,
+	route53.ErrCodeNoSuchHealthCheck:       true,
+	route53.ErrCodeNoSuchChange:            true,
+	route53.ErrCodeHostedZoneAlreadyExists: true,
+	route53.ErrCodeConflictingDomainExists: true,
+	route53.ErrCodeHostedZoneNotEmpty:      true,
+	route53.ErrCodeTooManyHostedZones:      true,
+	route53.ErrCodeLimitsExceeded:          true,
+}
+
+// isRetryable returns true for errors of transient failures. Codes of
+// neither class are retried on server errors, or as the sdk would.
+func isRetryable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr == nil {
+		return false
+	}
+
+	if retryableCodes[aerr.Code()] {
+		return true
+	}
+
+	if permanentCodes[aerr.Code()] {
+		return false
+	}
+
+	if rf, ok := err.(awserr.RequestFailure); ok && (rf.StatusCode() >= 500 || rf.StatusCode() == 429) {
+		return true
+	}
+
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
+}
+
+// retryBudget is shared by every client of an event, so batched changes
+// can not multiply the per call retries
+type retryBudget struct {
+	mu        sync.Mutex
+	retries   int
+	deadline  time.Time
+	exhausted bool
+}
+
+func newRetryBudget() *retryBudget {
+	return &retryBudget{deadline: now().Add(retryBudgetTimeout)}
+}
+
+// spend takes a retry from the budget, returning false once it is used up
+func (b *retryBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.retries >= maxEventRetries || now().After(b.deadline) {
+		b.exhausted = true
+		return false
+	}
+
+	b.retries++
+
+	return true
+}
+
+func (b *retryBudget) isExhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.exhausted
+}
+
+// budgetRetryer retries as the sdk does, while the event's budget allows
+type budgetRetryer struct {
+	client.DefaultRetryer
+	budget *retryBudget
+}
+
+func (r budgetRetryer) ShouldRetry(req *request.Request) bool {
+	if r.NumMaxRetries == 0 || req.RetryCount >= r.MaxRetries() {
+		return false
+	}
+
+	// handlers can rule out a retry, such as for a canceled request, or ask
+	// for one, which still takes from the budget
+	if req.Retryable != nil {
+		return *req.Retryable && r.budget.spend()
+	}
+
+	if !isRetryable(req.Error) {
+		return false
+	}
+
+	return r.budget.spend()
+}
+
+// budgetError reports an event that failed once its retry budget was used up
+func budgetError(ev *Event, err error) error {
+	if err == nil || ev.budget == nil || !ev.budget.isExhausted() {
+		return err
+	}
+
+	return fmt.Errorf("%s: %s", ErrRetryBudgetExceeded.Error(), errorMessage(err))
+}
@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryBudget(t *testing.T) {
+	Convey("Given an aws endpoint that keeps failing", t, func() {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+
+		e := testEvent
+		e.budget = newRetryBudget()
+		maxEventRetries = 4
+		Reset(func() {
+			server.Close()
+			maxEventRetries = 20
+		})
+
+		svc := route53.New(session.New(), clientConfig(&e, "eu-west-1").WithEndpoint(server.URL))
+
+		Convey("When several calls of the event retry", func() {
+			_, first := svc.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String("/hostedzone/TEST")})
+			_, second := svc.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String("/hostedzone/TEST")})
+
+			Convey("It should stop retrying once the budget is used up", func() {
+				So(first, ShouldNotBeNil)
+				So(second, ShouldNotBeNil)
+				So(requests, ShouldEqual, 6)
+				So(e.budget.isExhausted(), ShouldBeTrue)
+			})
+
+			Convey("It should fail the event with a budget exceeded error", func() {
+				err := budgetError(&e, second)
+				So(strings.HasPrefix(err.Error(), "Route53 retry budget exceeded: "), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a call retries within the budget", func() {
+			_, err := svc.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String("/hostedzone/TEST")})
+
+			Convey("It should return the aws error as it is", func() {
+				So(requests, ShouldEqual, 4)
+				So(e.budget.isExhausted(), ShouldBeFalse)
+				So(budgetError(&e, err), ShouldEqual, err)
+			})
+		})
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	Convey("Given aws errors of transient failures", t, func() {
+		for _, code := range []string{"Throttling", "PriorRequestNotComplete", "InternalError", "ServiceUnavailable"} {
+			err := awserr.New(code, "transient", nil)
+
+			Convey("It should retry "+code, func() {
+				So(isRetryable(err), ShouldBeTrue)
+			})
+		}
+
+		Convey("It should retry unknown codes of server errors", func() {
+			err := awserr.NewRequestFailure(awserr.New("Unknown", "server error", nil), 502, "request-id")
+			So(isRetryable(err), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given aws errors of permanent failures", t, func() {
+		for _, code := range []string{"NoSuchHostedZone", "InvalidInput", "InvalidChangeBatch", "AccessDenied"} {
+			err := awserr.NewRequestFailure(awserr.New(code, "permanent", nil), 400, "request-id")
+
+			Convey("It should not retry "+code, func() {
+				So(isRetryable(err), ShouldBeFalse)
+			})
+		}
+
+		Convey("It should not retry errors that are not from aws", func() {
+			So(isRetryable(errors.New("not from aws")), ShouldBeFalse)
+			So(isRetryable(nil), ShouldBeFalse)
+		})
+	})
+}
+
+func TestBudgetRetryerDelay(t *testing.T) {
+	Convey("Given a call that has retried many times", t, func() {
+		e := testEvent
+		e.budget = newRetryBudget()
+		retryer := clientConfig(&e, "eu-west-1").Retryer.(budgetRetryer)
+
+		req := &request.Request{
+			RetryCount:   20,
+			HTTPResponse: &http.Response{StatusCode: 500},
+			Error:        awserr.New("InternalError", "server error", nil),
+		}
+
+		Convey("It should cap the backoff", func() {
+			So(retryer.RetryRules(req), ShouldBeLessThanOrEqualTo, maxRetryDelay)
+		})
+	})
+}
+
+func TestBudgetRetryerRetryable(t *testing.T) {
+	Convey("Given a call a handler marked retryable", t, func() {
+		maxEventRetries = 1
+		Reset(func() { maxEventRetries = 20 })
+
+		e := testEvent
+		e.budget = newRetryBudget()
+		config := clientConfig(&e, "eu-west-1")
+		retryer := config.Retryer.(budgetRetryer)
+
+		req := &request.Request{
+			Retryable: aws.Bool(true),
+			Error:     errors.New("connection reset"),
+		}
+
+		Convey("It should have the sdk ask the retryer", func() {
+			So(aws.BoolValue(config.EnforceShouldRetryCheck), ShouldBeTrue)
+		})
+
+		Convey("It should take each retry from the budget", func() {
+			So(retryer.ShouldRetry(req), ShouldBeTrue)
+			So(retryer.ShouldRetry(req), ShouldBeFalse)
+			So(e.budget.isExhausted(), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// managedTagsKey is the tag listing the keys of the zone's tags set by the
+// connector, so tags set by other tools are never removed
+const managedTagsKey = "ernest:managed-tags"
+
+// maxTags is the most tags a hosted zone can have
+const maxTags = 50
+
+// maxTagChanges is the most tags route53 adds or removes in a single request
+var maxTagChanges = 10
+
+// reconcileTags sets the event's tags on the zone and removes the tags the
+// connector set before that the event no longer has. Events without tags
+// leave the zone's as they are.
+func reconcileTags(ev *Event) error {
+	if ev.Tags == nil {
+		return nil
+	}
+
+	svc := getRoute53Client(ev)
+	id := strings.TrimPrefix(ev.HostedZoneID, "/hostedzone/")
+
+	resp, err := svc.ListTagsForResource(&route53.ListTagsForResourceInput{
+		ResourceId:   aws.String(id),
+		ResourceType: aws.String(route53.TagResourceTypeHostedzone),
+	})
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]string)
+	if resp.ResourceTagSet != nil {
+		for _, tag := range resp.ResourceTagSet.Tags {
+			current[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+
+	keys := ev.tagKeys()
+
+	var add []*route53.Tag
+	var remove []*string
+
+	for _, key := range keys {
+		if value, ok := current[key]; !ok || value != ev.Tags[key] {
+			add = append(add, &route53.Tag{Key: aws.String(key), Value: aws.String(ev.Tags[key])})
+		}
+	}
+
+	for _, key := range strings.Split(current[managedTagsKey], ",") {
+		_, exists := current[key]
+		if _, wanted := ev.Tags[key]; key != "" && exists && !wanted {
+			remove = append(remove, aws.String(key))
+		}
+	}
+
+	marker, exists := current[managedTagsKey]
+	switch {
+	case len(keys) == 0 && exists:
+		remove = append(remove, aws.String(managedTagsKey))
+	case len(keys) > 0 && marker != strings.Join(keys, ","):
+		add = append(add, &route53.Tag{Key: aws.String(managedTagsKey), Value: aws.String(strings.Join(keys, ","))})
+	}
+
+	for len(add) > 0 || len(remove) > 0 {
+		req := &route53.ChangeTagsForResourceInput{
+			ResourceId:   aws.String(id),
+			ResourceType: aws.String(route53.TagResourceTypeHostedzone),
+		}
+
+		if n := len(add); n > 0 {
+			if n > maxTagChanges {
+				n = maxTagChanges
+			}
+			req.AddTags, add = add[:n], add[n:]
+		}
+
+		if n := len(remove); n > 0 {
+			if n > maxTagChanges {
+				n = maxTagChanges
+			}
+			req.RemoveTagKeys, remove = remove[:n], remove[n:]
+		}
+
+		if _, err := svc.ChangeTagsForResource(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tagKeys returns the keys of the event's tags in order
+func (ev *Event) tagKeys() []string {
+	var keys []string
+	for key := range ev.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateTags checks the event's tags are within the limits of route53,
+// leaving room for the tag listing them
+func (ev *Event) validateTags() error {
+	if len(ev.Tags) > maxTags-1 {
+		return fmt.Errorf("Route53 zone can have at most %d tags, %d given", maxTags-1, len(ev.Tags))
+	}
+
+	for key, value := range ev.Tags {
+		if key == managedTagsKey {
+			return fmt.Errorf("Route53 tag %s is reserved for the connector", key)
+		}
+
+		if key == "" || len(key) > 128 || strings.Contains(key, ",") {
+			return fmt.Errorf("Route53 tag key '%s' must be 1 to 128 characters without commas", key)
+		}
+
+		if len(value) > 256 {
+			return fmt.Errorf("Route53 tag %s value must be at most 256 characters", key)
+		}
+	}
+
+	if keys := strings.Join(ev.tagKeys(), ","); len(keys) > 256 {
+		return fmt.Errorf("Route53 tag keys must be at most 256 characters combined, %d given", len(keys))
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcileTags(t *testing.T) {
+	Convey("Given a zone with tags set by the connector and by other tools", t, func() {
+		e := testEvent
+		e.HostedZoneID = "/hostedzone/TEST"
+
+		c := &testRoute53Client{
+			tags: map[string]string{
+				"environment":  "staging",
+				"owner":        "web",
+				"cost-center":  "1234",
+				"backup":       "daily",
+				managedTagsKey: "environment,owner",
+			},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When reconciling the event's tags", func() {
+			e.Tags = map[string]string{"environment": "production", "team": "platform"}
+			err := reconcileTags(&e)
+
+			Convey("It should set the event's tags and remove the connector's old ones", func() {
+				So(err, ShouldBeNil)
+				So(c.tags["environment"], ShouldEqual, "production")
+				So(c.tags["team"], ShouldEqual, "platform")
+				So(c.tags, ShouldNotContainKey, "owner")
+				So(c.tags[managedTagsKey], ShouldEqual, "environment,team")
+			})
+
+			Convey("It should leave the tags of other tools", func() {
+				So(c.tags["cost-center"], ShouldEqual, "1234")
+				So(c.tags["backup"], ShouldEqual, "daily")
+			})
+
+			Convey("It should tag the hosted zone by its id", func() {
+				So(len(c.tagChanges), ShouldEqual, 1)
+				So(*c.tagChanges[0].ResourceId, ShouldEqual, "TEST")
+				So(*c.tagChanges[0].ResourceType, ShouldEqual, "hostedzone")
+			})
+
+			Convey("And reconciling them again", func() {
+				err := reconcileTags(&e)
+
+				Convey("It should not change anything", func() {
+					So(err, ShouldBeNil)
+					So(len(c.tagChanges), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When the event has no tags", func() {
+			e.Tags = map[string]string{}
+			err := reconcileTags(&e)
+
+			Convey("It should only remove the connector's tags", func() {
+				So(err, ShouldBeNil)
+				So(c.tags, ShouldResemble, map[string]string{"cost-center": "1234", "backup": "daily"})
+			})
+		})
+
+		Convey("When the event does not mention tags", func() {
+			err := reconcileTags(&e)
+
+			Convey("It should leave the zone's tags as they are", func() {
+				So(err, ShouldBeNil)
+				So(len(c.tagChanges), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When more tags change than route53 takes in a request", func() {
+			e.Tags = make(map[string]string)
+			for i := 0; i < 15; i++ {
+				e.Tags[fmt.Sprintf("key-%02d", i)] = "value"
+			}
+			err := reconcileTags(&e)
+
+			Convey("It should split the changes across requests", func() {
+				So(err, ShouldBeNil)
+				So(len(c.tagChanges), ShouldEqual, 2)
+				So(len(c.tagChanges[0].AddTags), ShouldEqual, 10)
+				So(len(c.tagChanges[1].AddTags), ShouldEqual, 6)
+				So(c.tags, ShouldContainKey, "key-14")
+				So(c.tags, ShouldContainKey, "cost-center")
+			})
+		})
+	})
+
+	Convey("Given an event tagging the connector's own tag", t, func() {
+		e := testEvent
+		e.Tags = map[string]string{managedTagsKey: "environment"}
+
+		Convey("When validating the event", func() {
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 tag ernest:managed-tags is reserved for the connector")
+			})
+		})
+	})
+}
@@ -0,0 +1,150 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// validateTrafficPolicy checks the event has what an instance of its traffic
+// policy needs
+func (ev *Event) validateTrafficPolicy() error {
+	if ev.TrafficPolicyVersion < 1 {
+		return errors.New("Route53 traffic policy requires a version")
+	}
+
+	if ev.TrafficPolicyRecord == "" {
+		return errors.New("Route53 traffic policy requires a record name")
+	}
+
+	if err := validateEntryName(ev.TrafficPolicyRecord); err != nil {
+		return fmt.Errorf("Route53 traffic policy record %s is invalid: %s", ev.TrafficPolicyRecord, err.Error())
+	}
+
+	for _, record := range ev.Records {
+		if sameName(record.Entry, ev.TrafficPolicyRecord) {
+			return fmt.Errorf("Record %s is managed by the traffic policy and can not be set directly", record.Entry)
+		}
+	}
+
+	return nil
+}
+
+// isTrafficPolicyRecord returns true for the record sets created by the
+// event's traffic policy instance, which are left to the instance
+func isTrafficPolicyRecord(ev *Event, record *route53.ResourceRecordSet) bool {
+	return ev.TrafficPolicyID != "" && sameName(ev.TrafficPolicyRecord, *record.Name)
+}
+
+// findTrafficPolicyInstance returns the id of the traffic policy instance of
+// the event's record name, or an empty id if there is none
+func findTrafficPolicyInstance(ev *Event) (string, error) {
+	if ev.TrafficPolicyInstanceID != "" {
+		return ev.TrafficPolicyInstanceID, nil
+	}
+
+	svc := getRoute53ReadClient(ev)
+
+	req := &route53.ListTrafficPolicyInstancesByHostedZoneInput{
+		HostedZoneId: aws.String(ev.HostedZoneID),
+	}
+
+	for {
+		resp, err := svc.ListTrafficPolicyInstancesByHostedZone(req)
+		if err != nil {
+			return "", err
+		}
+
+		for _, instance := range resp.TrafficPolicyInstances {
+			if sameName(*instance.Name, ev.TrafficPolicyRecord) {
+				return *instance.Id, nil
+			}
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return "", nil
+		}
+
+		req.TrafficPolicyInstanceNameMarker = resp.TrafficPolicyInstanceNameMarker
+		req.TrafficPolicyInstanceTypeMarker = resp.TrafficPolicyInstanceTypeMarker
+	}
+}
+
+// applyTrafficPolicy creates the traffic policy instance of the event, or
+// updates it to the event's version and ttl
+func applyTrafficPolicy(ev *Event) error {
+	if ev.TrafficPolicyID == "" {
+		return nil
+	}
+
+	id, err := findTrafficPolicyInstance(ev)
+	if err != nil {
+		return err
+	}
+
+	svc := getRoute53Client(ev)
+	ttl := recordTTL(ev, Record{Entry: ev.TrafficPolicyRecord, TTL: ev.TrafficPolicyTTL})
+
+	if id == "" {
+		resp, err := svc.CreateTrafficPolicyInstance(&route53.CreateTrafficPolicyInstanceInput{
+			HostedZoneId:         aws.String(ev.HostedZoneID),
+			Name:                 aws.String(canonicalName(ev.TrafficPolicyRecord)),
+			TTL:                  aws.Int64(ttl),
+			TrafficPolicyId:      aws.String(ev.TrafficPolicyID),
+			TrafficPolicyVersion: aws.Int64(ev.TrafficPolicyVersion),
+		})
+		if err != nil {
+			return err
+		}
+
+		ev.TrafficPolicyInstanceID = *resp.TrafficPolicyInstance.Id
+
+		return nil
+	}
+
+	_, err = svc.UpdateTrafficPolicyInstance(&route53.UpdateTrafficPolicyInstanceInput{
+		Id:                   aws.String(id),
+		TTL:                  aws.Int64(ttl),
+		TrafficPolicyId:      aws.String(ev.TrafficPolicyID),
+		TrafficPolicyVersion: aws.Int64(ev.TrafficPolicyVersion),
+	})
+	if err != nil {
+		return err
+	}
+
+	ev.TrafficPolicyInstanceID = id
+
+	return nil
+}
+
+// deleteTrafficPolicy deletes the traffic policy instance of the event along
+// with the record sets it created
+func deleteTrafficPolicy(ev *Event) error {
+	if ev.TrafficPolicyID == "" {
+		return nil
+	}
+
+	id, err := findTrafficPolicyInstance(ev)
+	if err != nil || id == "" {
+		return err
+	}
+
+	svc := getRoute53Client(ev)
+
+	_, err = svc.DeleteTrafficPolicyInstance(&route53.DeleteTrafficPolicyInstanceInput{
+		Id: aws.String(id),
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == route53.ErrCodeNoSuchTrafficPolicyInstance {
+		return nil
+	}
+
+	return err
+}
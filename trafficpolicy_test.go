@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTrafficPolicy(t *testing.T) {
+	Convey("Given an event with a traffic policy", t, func() {
+		e := testEvent
+		e.TrafficPolicyID = "12345678-1234-1234-1234-123456789012"
+		e.TrafficPolicyVersion = 1
+		e.TrafficPolicyRecord = "app.test"
+		e.TrafficPolicyTTL = 60
+
+		c := &testRoute53Client{}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		Convey("When creating the zone", func() {
+			err := createRoute53(&e)
+
+			Convey("It should create an instance of the policy for the record", func() {
+				So(err, ShouldBeNil)
+				So(len(c.policyInstances), ShouldEqual, 1)
+				So(*c.policyInstances[0].HostedZoneId, ShouldEqual, "/hostedzone/CREATED")
+				So(*c.policyInstances[0].Name, ShouldEqual, "app.test.")
+				So(*c.policyInstances[0].TTL, ShouldEqual, 60)
+				So(*c.policyInstances[0].TrafficPolicyVersion, ShouldEqual, 1)
+				So(e.TrafficPolicyInstanceID, ShouldEqual, "instance-1")
+			})
+		})
+
+		Convey("With an existing instance of the policy", func() {
+			e.HostedZoneID = "/hostedzone/TEST"
+			c.zones = []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			}
+			c.policyInstances = []*route53.TrafficPolicyInstance{
+				{Id: aws.String("instance-1"), Name: aws.String("app.test."), TTL: aws.Int64(60), TrafficPolicyId: aws.String(e.TrafficPolicyID), TrafficPolicyVersion: aws.Int64(1)},
+			}
+			c.records = []*route53.ResourceRecordSet{
+				{Name: aws.String("app.test."), Type: aws.String("A"), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}}},
+			}
+
+			Convey("When updating the zone to a new version", func() {
+				e.TrafficPolicyVersion = 2
+				err := updateRoute53(&e)
+
+				Convey("It should update the instance", func() {
+					So(err, ShouldBeNil)
+					So(len(c.policyInstances), ShouldEqual, 1)
+					So(*c.policyInstances[0].TrafficPolicyVersion, ShouldEqual, 2)
+				})
+
+				Convey("It should leave the records of the instance alone", func() {
+					for _, change := range c.changes {
+						for _, ch := range change.ChangeBatch.Changes {
+							So(*ch.ResourceRecordSet.Name, ShouldNotEqual, "app.test.")
+						}
+					}
+				})
+			})
+
+			Convey("When deleting the zone", func() {
+				err := deleteRoute53(&e)
+
+				Convey("It should delete the instance before the zone", func() {
+					So(err, ShouldBeNil)
+					So(c.deletedPolicyInstances, ShouldResemble, []string{"instance-1"})
+					So(c.deleted, ShouldResemble, []string{"/hostedzone/TEST"})
+				})
+			})
+		})
+	})
+
+	Convey("Given an event with a traffic policy without a version", t, func() {
+		e := testEvent
+		e.TrafficPolicyID = "12345678-1234-1234-1234-123456789012"
+		e.TrafficPolicyRecord = "www.test"
+
+		Convey("When validating the event", func() {
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Route53 traffic policy requires a version")
+			})
+		})
+	})
+
+	Convey("Given an event with a record managed by its traffic policy", t, func() {
+		e := testEvent
+		e.TrafficPolicyID = "12345678-1234-1234-1234-123456789012"
+		e.TrafficPolicyVersion = 1
+		e.TrafficPolicyRecord = "www.test"
+
+		Convey("When validating the event", func() {
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Record www.test is managed by the traffic policy and can not be set directly")
+			})
+		})
+	})
+}
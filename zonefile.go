@@ -0,0 +1,372 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrZoneFileEmpty : error for an import without a zone file
+var ErrZoneFileEmpty = errors.New("Route53 import requires a zone file")
+
+// zoneFileTypes are the record types read from a zone file
+var zoneFileTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"MX":    true,
+	"TXT":   true,
+	"NS":    true,
+	"SRV":   true,
+}
+
+// ttlUnits are the multipliers of the units a zone file ttl can be given in
+var ttlUnits = map[rune]int64{
+	's': 1,
+	'm': 60,
+	'h': 60 * 60,
+	'd': 24 * 60 * 60,
+	'w': 7 * 24 * 60 * 60,
+}
+
+// zoneFileLine is an entry of a zone file, with any lines continued in
+// parentheses joined into it
+type zoneFileLine struct {
+	number     int
+	blankOwner bool
+	fields     []string
+}
+
+// validateZoneFile checks the zone file of an import event and the records
+// read from it
+func (ev *Event) validateZoneFile() error {
+	if strings.TrimSpace(ev.ZoneFile) == "" {
+		return ErrZoneFileEmpty
+	}
+
+	records, err := parseZoneFile(ev.ZoneFile, ev.Name, ev.ManageDefaultRecords)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := record.Validate(ev.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importRoute53 creates the zone from the event's zone file, or updates it
+// when it already exists
+func importRoute53(ev *Event) error {
+	records, err := parseZoneFile(ev.ZoneFile, ev.Name, ev.ManageDefaultRecords)
+	if err != nil {
+		return err
+	}
+
+	ev.Records = records
+
+	err = resolveZoneID(ev)
+	if err == ErrHostedZoneNotFound {
+		ev.AllowEmptyZone = true
+		return createRoute53(ev)
+	}
+	if err != nil {
+		return err
+	}
+
+	return updateRoute53(ev)
+}
+
+// parseZoneFile reads the records of a bind zone file. Names are relative to
+// the zone unless the file sets another $ORIGIN. The apex SOA is managed by
+// route53, as is the apex NS unless the event manages default records.
+func parseZoneFile(data, zone string, manageDefaultRecords bool) (Records, error) {
+	lines, err := zoneFileLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records Records
+	var defaultTTL, lastTTL int64
+	var owner string
+
+	index := make(map[string]int)
+	origin := entryName(zone)
+
+	for _, line := range lines {
+		fields := line.fields
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("Zone file line %d: $ORIGIN requires a name", line.number)
+			}
+			origin = qualifyName(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("Zone file line %d: $TTL requires a ttl", line.number)
+			}
+			if defaultTTL, err = parseZoneFileTTL(fields[1]); err != nil {
+				return nil, fmt.Errorf("Zone file line %d: %s", line.number, err.Error())
+			}
+			continue
+		case "$INCLUDE", "$GENERATE":
+			return nil, fmt.Errorf("Zone file line %d: %s is not supported", line.number, fields[0])
+		}
+
+		if !line.blankOwner {
+			owner = qualifyName(fields[0], origin)
+			fields = fields[1:]
+		}
+
+		if owner == "" {
+			return nil, fmt.Errorf("Zone file line %d: record has no name", line.number)
+		}
+
+		ttl := defaultTTL
+		if ttl == 0 {
+			ttl = lastTTL
+		}
+
+		// the ttl and class can come in either order before the type
+		for len(fields) > 0 {
+			if strings.EqualFold(fields[0], "IN") {
+				fields = fields[1:]
+			} else if unicode.IsDigit(rune(fields[0][0])) {
+				if ttl, err = parseZoneFileTTL(fields[0]); err != nil {
+					return nil, fmt.Errorf("Zone file line %d: %s", line.number, err.Error())
+				}
+				fields = fields[1:]
+			} else {
+				break
+			}
+		}
+
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Zone file line %d: record requires a type and value", line.number)
+		}
+
+		lastTTL = ttl
+		recordType := strings.ToUpper(fields[0])
+
+		if recordType == "SOA" {
+			continue
+		}
+
+		if recordType == "NS" && owner == entryName(zone) && !manageDefaultRecords {
+			continue
+		}
+
+		if !zoneFileTypes[recordType] {
+			return nil, fmt.Errorf("Zone file line %d: record type %s is not supported", line.number, fields[0])
+		}
+
+		value, err := zoneFileValue(recordType, fields[1:], origin)
+		if err != nil {
+			return nil, fmt.Errorf("Zone file line %d: %s", line.number, err.Error())
+		}
+
+		// lines of the same name and type are values of one record set
+		key := strings.ToLower(owner) + " " + recordType
+		if i, ok := index[key]; ok {
+			records[i].Values = append(records[i].Values, value)
+			continue
+		}
+
+		index[key] = len(records)
+		records = append(records, Record{
+			Entry:  owner,
+			Type:   recordType,
+			TTL:    ttl,
+			Values: []string{value},
+		})
+	}
+
+	return records, nil
+}
+
+// zoneFileValue renders the data of a zone file record as a record value,
+// qualifying the names it refers to
+func zoneFileValue(recordType string, data []string, origin string) (string, error) {
+	expected := map[string]int{"A": 1, "AAAA": 1, "CNAME": 1, "NS": 1, "MX": 2, "SRV": 4}
+
+	if n, ok := expected[recordType]; ok && len(data) != n {
+		return "", fmt.Errorf("%s record requires %d values, got %d", recordType, n, len(data))
+	}
+
+	switch recordType {
+	case "CNAME", "NS":
+		return qualifyName(data[0], origin), nil
+	case "MX":
+		return data[0] + " " + qualifyName(data[1], origin), nil
+	case "SRV":
+		return strings.Join(data[:3], " ") + " " + qualifyName(data[3], origin), nil
+	case "TXT":
+		// the strings of a txt record are a single value
+		var value string
+		for _, s := range data {
+			value += unquoteZoneFileString(s)
+		}
+		return value, nil
+	}
+
+	return data[0], nil
+}
+
+// qualifyName returns a zone file name as a full name, without its trailing
+// dot. Names without a trailing dot are relative to the origin.
+func qualifyName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return entryName(name)
+	case origin == "":
+		return name
+	}
+	return name + "." + origin
+}
+
+// parseZoneFileTTL reads a ttl in seconds, or with units such as 1h30m
+func parseZoneFileTTL(value string) (int64, error) {
+	if ttl, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ttl, nil
+	}
+
+	var ttl, n int64
+	var digits bool
+
+	for _, c := range strings.ToLower(value) {
+		if unicode.IsDigit(c) {
+			n = n*10 + int64(c-'0')
+			digits = true
+			continue
+		}
+
+		unit, ok := ttlUnits[c]
+		if !ok || !digits {
+			return 0, fmt.Errorf("invalid ttl %s", value)
+		}
+
+		ttl += n * unit
+		n, digits = 0, false
+	}
+
+	if digits {
+		return 0, fmt.Errorf("invalid ttl %s", value)
+	}
+
+	return ttl, nil
+}
+
+// zoneFileLines splits a zone file into its entries, dropping comments and
+// blank lines
+func zoneFileLines(data string) ([]zoneFileLine, error) {
+	var lines []zoneFileLine
+	var current *zoneFileLine
+	var depth int
+
+	for i, raw := range strings.Split(data, "\n") {
+		fields := zoneFileFields(raw)
+
+		if current == nil {
+			if len(fields) == 0 {
+				continue
+			}
+			current = &zoneFileLine{
+				number:     i + 1,
+				blankOwner: raw[0] == ' ' || raw[0] == '\t',
+			}
+		}
+
+		for _, field := range fields {
+			switch field {
+			case "(":
+				depth++
+			case ")":
+				depth--
+				if depth < 0 {
+					return nil, fmt.Errorf("Zone file line %d: unbalanced parentheses", i+1)
+				}
+			default:
+				current.fields = append(current.fields, field)
+			}
+		}
+
+		if depth == 0 {
+			if len(current.fields) > 0 {
+				lines = append(lines, *current)
+			}
+			current = nil
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("Zone file line %d: unbalanced parentheses", current.number)
+	}
+
+	return lines, nil
+}
+
+// zoneFileFields splits a zone file line into its fields, keeping quoted
+// strings whole and separating parentheses
+func zoneFileFields(line string) []string {
+	var fields []string
+	var field []rune
+	var quoted, escaped bool
+
+	flush := func() {
+		if len(field) > 0 {
+			fields = append(fields, string(field))
+			field = nil
+		}
+	}
+
+	for _, c := range strings.TrimRight(line, "\r") {
+		switch {
+		case escaped:
+			field = append(field, c)
+			escaped = false
+		case c == '\\':
+			field = append(field, c)
+			escaped = true
+		case c == '"':
+			field = append(field, c)
+			quoted = !quoted
+		case quoted:
+			field = append(field, c)
+		case c == ';':
+			flush()
+			return fields
+		case c == '(' || c == ')':
+			flush()
+			fields = append(fields, string(c))
+		case unicode.IsSpace(c):
+			flush()
+		default:
+			field = append(field, c)
+		}
+	}
+
+	flush()
+
+	return fields
+}
+
+// unquoteZoneFileString returns the contents of a zone file string
+func unquoteZoneFileString(s string) string {
+	if isQuoted(s) {
+		s = s[1 : len(s)-1]
+	}
+	return strings.Replace(s, `\"`, `"`, -1)
+}
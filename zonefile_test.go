@@ -0,0 +1,173 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testZoneFile = `$ORIGIN test.
+$TTL 1h
+@       IN  SOA ns-1.test. hostmaster.test. (
+                2024010101 ; serial
+                7200       ; refresh
+                900        ; retry
+                1209600    ; expire
+                86400 )    ; minimum
+@           NS     ns-1.test.
+@           MX     10 mail
+            MX     20 mail.example.com.
+mail    300 IN A   10.0.0.1
+www     IN  300 A  10.0.0.2
+            A      10.0.0.3 ; same owner
+www         AAAA   2001:db8::1
+cdn         CNAME  cdn.example.com.
+@           TXT    "v=spf1 include:_spf.example.com -all"
+long        TXT    ( "first part "
+                     "second part" )
+_sip._tcp   SRV    10 60 5060 sip
+
+$ORIGIN sub.test.
+api         A      10.0.1.1
+`
+
+func TestParseZoneFile(t *testing.T) {
+	Convey("Given a bind zone file", t, func() {
+		Convey("When parsing it", func() {
+			records, err := parseZoneFile(testZoneFile, "test", false)
+
+			Convey("It should read every supported record", func() {
+				So(err, ShouldBeNil)
+				So(len(records), ShouldEqual, 9)
+			})
+
+			Convey("It should skip the apex SOA and NS", func() {
+				for _, record := range records {
+					So(record.Type, ShouldNotEqual, "SOA")
+					So(record.Type, ShouldNotEqual, "NS")
+				}
+			})
+
+			Convey("It should group values of the same name and type", func() {
+				So(records[0], ShouldResemble, Record{Entry: "test", Type: "MX", TTL: 3600, Values: []string{"10 mail.test", "20 mail.example.com"}})
+				So(records[2], ShouldResemble, Record{Entry: "www.test", Type: "A", TTL: 300, Values: []string{"10.0.0.2", "10.0.0.3"}})
+			})
+
+			Convey("It should apply the ttl of a record or the default", func() {
+				So(records[1], ShouldResemble, Record{Entry: "mail.test", Type: "A", TTL: 300, Values: []string{"10.0.0.1"}})
+				So(records[3], ShouldResemble, Record{Entry: "www.test", Type: "AAAA", TTL: 3600, Values: []string{"2001:db8::1"}})
+			})
+
+			Convey("It should qualify relative names", func() {
+				So(records[4].Values, ShouldResemble, []string{"cdn.example.com"})
+				So(records[7], ShouldResemble, Record{Entry: "_sip._tcp.test", Type: "SRV", TTL: 3600, Values: []string{"10 60 5060 sip.test"}})
+				So(records[8].Entry, ShouldEqual, "api.sub.test")
+			})
+
+			Convey("It should join the strings of a txt record", func() {
+				So(records[5].Values, ShouldResemble, []string{"v=spf1 include:_spf.example.com -all"})
+				So(records[6].Values, ShouldResemble, []string{"first part second part"})
+			})
+		})
+
+		Convey("When the event manages default records", func() {
+			records, _ := parseZoneFile(testZoneFile, "test", true)
+
+			Convey("It should read the apex NS", func() {
+				So(len(records), ShouldEqual, 10)
+				So(records[0], ShouldResemble, Record{Entry: "test", Type: "NS", TTL: 3600, Values: []string{"ns-1.test"}})
+			})
+		})
+	})
+
+	Convey("Given zone files that can not be imported", t, func() {
+		tests := map[string]string{
+			"www IN PTR host.test.":     "Zone file line 1: record type PTR is not supported",
+			"$INCLUDE other.zone":       "Zone file line 1: $INCLUDE is not supported",
+			"www IN MX mail":            "Zone file line 1: MX record requires 2 values, got 1",
+			"www 5x IN A 10.0.0.1":      "Zone file line 1: invalid ttl 5x",
+			"www IN TXT ( \"unclosed\"": "Zone file line 1: unbalanced parentheses",
+		}
+
+		for data, expected := range tests {
+			Convey("When parsing "+data, func() {
+				_, err := parseZoneFile(data, "test", false)
+
+				Convey("It should error", func() {
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldEqual, expected)
+				})
+			})
+		}
+	})
+}
+
+func TestImportRoute53(t *testing.T) {
+	Convey("Given an event importing a zone file", t, func() {
+		c := &testRoute53Client{
+			zones: []*route53.HostedZone{},
+		}
+		testClient(c)
+		Reset(func() {
+			getRoute53Client = newRoute53Client
+		})
+
+		e := testEvent
+		e.Records = nil
+		e.ZoneFile = testZoneFile
+
+		Convey("When the zone does not exist", func() {
+			c.onList = func(n int) {
+				// route53 stores the records of the zone once they are applied
+				if len(c.changes) > 0 {
+					c.records = nil
+					for _, change := range c.changes[0].ChangeBatch.Changes {
+						c.records = append(c.records, change.ResourceRecordSet)
+					}
+				}
+			}
+			err := importRoute53(&e)
+
+			Convey("It should create the zone with the records of the file", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 1)
+				So(len(e.Records), ShouldEqual, 9)
+				So(len(c.changes), ShouldEqual, 1)
+				So(len(c.changes[0].ChangeBatch.Changes), ShouldEqual, 9)
+			})
+		})
+
+		Convey("When the zone exists", func() {
+			c.zones = []*route53.HostedZone{
+				{Id: aws.String("/hostedzone/TEST"), Name: aws.String("test."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+			}
+			err := importRoute53(&e)
+
+			Convey("It should update the zone", func() {
+				So(err, ShouldBeNil)
+				So(len(c.created), ShouldEqual, 0)
+				So(e.HostedZoneID, ShouldEqual, "/hostedzone/TEST")
+				So(len(c.changes), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given an import event without a zone file", t, func() {
+		e := testEvent
+		e.action = "import"
+
+		Convey("When validating the event", func() {
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrZoneFileEmpty)
+			})
+		})
+	})
+}